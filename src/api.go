@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// DaemonStatus is returned by the control API's /status endpoint.
+type DaemonStatus struct {
+	StartedAt time.Time       `json:"startedAt"`
+	Interval  int             `json:"intervalSeconds"`
+	DryRun    bool            `json:"dryRun,omitempty"`
+	Current   string          `json:"current,omitempty"`
+	NextCycle time.Time       `json:"nextCycle,omitzero"`
+	Config    *ConfigStatus   `json:"config,omitempty"` // Set when the config comes from git
+	Projects  []ProjectStatus `json:"projects"`
+}
+
+type triggerRequest struct {
+	Projects []string `json:"projects"` // Empty triggers all projects
+}
+
+// updateRequest asks the daemon to update projects now and wait for the
+// results, for the update, rollback and apply commands.
+type updateRequest struct {
+	Projects []string          `json:"projects"`
+	Targets  map[string]string `json:"targets,omitempty"` // Revisions fixed by a saved plan
+}
+
+type projectRequest struct {
+	Project string `json:"project"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func socketPath() string {
+	return filepath.Join(stateDir(), "updatectrl.sock")
+}
+
+// errDaemonRunning is returned by listenAPI when another daemon holds the
+// control socket, and would update the same projects.
+var errDaemonRunning = errors.New("another updatectrl daemon is already running")
+
+// listenAPI opens the control socket, unless another daemon listens on it.
+func listenAPI() (net.Listener, error) {
+	path := socketPath()
+	if daemonRunning() {
+		return nil, fmt.Errorf("%w (socket %s)", errDaemonRunning, path)
+	}
+	if err := os.MkdirAll(stateDir(), 0755); err != nil {
+		return nil, err
+	}
+	os.Remove(path) // Stale socket left by a previous daemon
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	os.Chmod(path, 0660)
+	return listener, nil
+}
+
+// serveAPI exposes the control API on listener until the daemon exits.
+func (d *daemon) serveAPI(listener net.Listener) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", d.handleStatus)
+	mux.HandleFunc("POST /trigger", d.handleTrigger)
+	mux.HandleFunc("POST /update", d.handleUpdate)
+	mux.HandleFunc("POST /pause", d.handlePause)
+	mux.HandleFunc("POST /resume", d.handleResume)
+	mux.HandleFunc("GET /metrics", handleMetrics)
+
+	logStep(slog.Default(), "Control API listening", "socket", listener.Addr().String())
+	if err := http.Serve(listener, mux); err != nil {
+		slog.Error("Control API stopped", "error", err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, apiError{Error: err.Error()})
+}
+
+func (d *daemon) handleStatus(w http.ResponseWriter, r *http.Request) {
+	s, err := loadState()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	d.mu.Lock()
+	status := DaemonStatus{
+		StartedAt: d.startedAt,
+		Interval:  d.config.intervalSeconds(),
+		DryRun:    d.dryRun,
+		Current:   d.current,
+		NextCycle: d.nextCycle,
+		Config:    d.config.status(),
+		Projects:  []ProjectStatus{},
+	}
+	projects := d.config.Projects
+	d.mu.Unlock()
+
+	for _, p := range projects {
+		status.Projects = append(status.Projects, recordedStatus(p, s))
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (d *daemon) handleTrigger(w http.ResponseWriter, r *http.Request) {
+	var req triggerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := d.trigger(req.Projects); err != nil {
+		code := http.StatusConflict
+		if errors.Is(err, errUnknownProject) {
+			code = http.StatusNotFound
+		}
+		writeError(w, code, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, req)
+}
+
+func (d *daemon) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	var req updateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(req.Projects) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("no projects to update"))
+		return
+	}
+
+	d.mu.Lock()
+	config := d.config
+	d.mu.Unlock()
+	projects, err := selectProjects(config, req.Projects)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	for i := range projects {
+		projects[i].target = req.Targets[projects[i].Name]
+	}
+
+	job := updateJob{projects: projects, done: make(chan []UpdateResult, 1)}
+	select {
+	case d.updates <- job:
+	default:
+		writeError(w, http.StatusConflict, fmt.Errorf("too many pending updates, try again later"))
+		return
+	}
+	select {
+	case results := <-job.done:
+		writeJSON(w, http.StatusOK, results)
+	case <-r.Context().Done():
+		// The update still runs; its result is recorded in the history
+	}
+}
+
+func (d *daemon) handlePause(w http.ResponseWriter, r *http.Request) {
+	d.setPaused(w, r, true)
+}
+
+func (d *daemon) handleResume(w http.ResponseWriter, r *http.Request) {
+	d.setPaused(w, r, false)
+}
+
+func (d *daemon) setPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	var req projectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	d.mu.Lock()
+	_, ok := findProject(d.config, req.Project)
+	d.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("project %s not found in configuration", req.Project))
+		return
+	}
+
+	err := updateState(func(s *State) {
+		s.project(req.Project).Paused = paused
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if paused {
+		logSkipped(slog.Default(), "Paused project", projectKey, req.Project)
+	} else {
+		resetBreaker(req.Project)
+		logStep(slog.Default(), "Resumed project", projectKey, req.Project)
+	}
+	writeJSON(w, http.StatusOK, req)
+}
+
+// daemonRunning reports whether a daemon is listening on the control socket.
+func daemonRunning() bool {
+	conn, err := net.Dial("unix", socketPath())
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// apiRequest sends a request to the control API of the running daemon and
+// decodes the response into out, if given.
+func apiRequest(method, path string, body, out any) error {
+	return apiRequestTimeout(10*time.Second, method, path, body, out)
+}
+
+// apiRequestTimeout is apiRequest with a custom timeout, zero for none.
+func apiRequestTimeout(timeout time.Duration, method, path string, body, out any) error {
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socketPath())
+			},
+		},
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, "http://updatectrl"+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not reach the daemon at %s (is `updatectrl watch` running?): %w", socketPath(), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var e apiError
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			return fmt.Errorf("daemon returned %s", resp.Status)
+		}
+		return errors.New(e.Error)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// updateThroughDaemon has the running daemon update projects and prints the
+// results. It returns the number of projects that failed.
+func updateThroughDaemon(req updateRequest) (int, error) {
+	var results []UpdateResult
+	if err := apiRequestTimeout(0, "POST", "/update", req, &results); err != nil {
+		return 0, err
+	}
+
+	failed := 0
+	for _, res := range results {
+		switch res.Outcome {
+		case outcomeFailed, outcomeSuspended:
+			fmt.Printf("✘ Update failed for %s: %s\n", res.Project, res.Error)
+			failed++
+		case outcomeUnchanged:
+			fmt.Printf("● %s is up to date\n", res.Project)
+		default:
+			fmt.Printf("✓ %s %s to %s\n", res.Project, res.Outcome, shortRevision(res.NewRevision))
+		}
+		if res.Logs != "" {
+			fmt.Println("  Logs:", res.Logs)
+		}
+	}
+	return failed, nil
+}
+
+var triggerCmd = &cobra.Command{
+	Use:   "trigger [project...]",
+	Short: "Ask the running daemon to check projects now",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := apiRequest("POST", "/trigger", triggerRequest{Projects: args}, nil); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		if len(args) == 0 {
+			fmt.Println("Triggered a check of all projects")
+		} else {
+			fmt.Println("Triggered a check of", args)
+		}
+	},
+}
+
+var pauseCmd = &cobra.Command{
+	Use:   "pause <project>",
+	Short: "Stop the running daemon from updating a project",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := apiRequest("POST", "/pause", projectRequest{Project: args[0]}, nil); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Paused", args[0])
+	},
+}
+
+var resumeCmd = &cobra.Command{
+	Use:   "resume <project>",
+	Short: "Resume updates of a paused or suspended project",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := apiRequest("POST", "/resume", projectRequest{Project: args[0]}, nil); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Resumed", args[0])
+	},
+}
+
+// printLiveStatus shows the status reported by the running daemon.
+func printLiveStatus(output string) {
+	var status DaemonStatus
+	if err := apiRequest("GET", "/status", nil, &status); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+
+	if output == "json" {
+		data, _ := json.MarshalIndent(status, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+
+	fmt.Printf("Daemon running since %s, checking every %d seconds\n", formatTime(status.StartedAt), status.Interval)
+	if status.DryRun {
+		fmt.Println("Dry run: no changes are made")
+	}
+	if status.Current != "" {
+		fmt.Println("Currently checking:", status.Current)
+	} else if !status.NextCycle.IsZero() {
+		fmt.Println("Next check:", formatTime(status.NextCycle))
+	}
+	printConfigStatus(status.Config)
+	fmt.Println()
+	printStatusTable(status.Projects)
+}
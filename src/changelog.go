@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Number of commits listed in a changelog; Count has the full number.
+const maxChangelogCommits = 50
+
+// OCI image labels pointing at the commit an image was built from.
+const (
+	labelRevision = "org.opencontainers.image.revision"
+	labelSource   = "org.opencontainers.image.source"
+)
+
+// Changelog is what a deployment changed in terms of commits.
+type Changelog struct {
+	Source     string   `json:"source,omitempty"` // Upstream repository of an image, from its OCI source label
+	From       string   `json:"from"`
+	To         string   `json:"to"`
+	Count      int      `json:"count,omitempty"`    // Number of commits, excluding merges; unknown for images
+	Reverted   bool     `json:"reverted,omitempty"` // The commits were removed by a rollback
+	Commits    []Commit `json:"commits,omitempty"`
+	CompareURL string   `json:"compareUrl,omitempty"`
+}
+
+type Commit struct {
+	SHA     string `json:"sha"`
+	Author  string `json:"author"`
+	Subject string `json:"subject"`
+}
+
+// gitChangelog lists the commits between two revisions of a checkout. When
+// to is an ancestor of from, as after a rollback, it lists the commits that
+// were reverted instead.
+func gitChangelog(path, from, to string) (*Changelog, error) {
+	if from == "" || to == "" || from == to {
+		return nil, nil
+	}
+
+	c := &Changelog{From: from, To: to}
+	count, err := gitCommitCount(path, from+".."+to)
+	if err != nil {
+		return nil, err
+	}
+	logRange := from + ".." + to
+	if count == 0 {
+		if count, err = gitCommitCount(path, to+".."+from); err != nil {
+			return nil, err
+		}
+		c.Reverted = true
+		logRange = to + ".." + from
+	}
+	c.Count = count
+
+	output, err := exec.Command("git", "-C", path, "log", "--no-merges", "-n", strconv.Itoa(maxChangelogCommits), "--format=%h%x1f%an%x1f%s", logRange).Output()
+	if err != nil {
+		return nil, fmt.Errorf("git log: %w", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.SplitN(line, "\x1f", 3)
+		if len(fields) == 3 {
+			c.Commits = append(c.Commits, Commit{SHA: fields[0], Author: fields[1], Subject: fields[2]})
+		}
+	}
+	return c, nil
+}
+
+func gitCommitCount(path, revRange string) (int, error) {
+	output, err := exec.Command("git", "-C", path, "rev-list", "--no-merges", "--count", revRange).Output()
+	if err != nil {
+		return 0, fmt.Errorf("git rev-list: %w", err)
+	}
+	return strconv.Atoi(strings.TrimSpace(string(output)))
+}
+
+// imageLabels returns the labels of a local image, nil if it doesn't exist.
+func imageLabels(image string) map[string]string {
+	output, err := exec.Command("docker", "image", "inspect", "--format", "{{json .Config.Labels}}", image).Output()
+	if err != nil {
+		return nil
+	}
+	var labels map[string]string
+	json.Unmarshal(output, &labels)
+	return labels
+}
+
+// imageChangelog returns the upstream commit range between two images built
+// with OCI revision labels. The commits themselves are not known locally.
+func imageChangelog(oldLabels, newLabels map[string]string) *Changelog {
+	from, to := oldLabels[labelRevision], newLabels[labelRevision]
+	if from == "" || to == "" || from == to {
+		return nil
+	}
+	c := &Changelog{Source: newLabels[labelSource], From: from, To: to}
+	c.CompareURL = compareURL(c.Source, from, to)
+	return c
+}
+
+// compareURL links to the diff of two commits on GitHub or GitLab.
+func compareURL(source, from, to string) string {
+	repo := strings.TrimSuffix(strings.TrimSuffix(source, "/"), ".git")
+	switch {
+	case strings.HasPrefix(repo, "https://github.com/"):
+		return repo + "/compare/" + from + "..." + to
+	case strings.HasPrefix(repo, "https://gitlab.com/"):
+		return repo + "/-/compare/" + from + "..." + to
+	}
+	return ""
+}
+
+// summary describes the changelog in a few words, e.g. "3 commits".
+func (c *Changelog) summary() string {
+	switch {
+	case c.Count == 1 && c.Reverted:
+		return "1 commit reverted"
+	case c.Count == 1:
+		return "1 commit"
+	case c.Count > 0 && c.Reverted:
+		return fmt.Sprintf("%d commits reverted", c.Count)
+	case c.Count > 0:
+		return fmt.Sprintf("%d commits", c.Count)
+	}
+	return shortRevision(c.From) + "..." + shortRevision(c.To)
+}
+
+// lines formats up to max commits, one per line.
+func (c *Changelog) lines(max int) []string {
+	var lines []string
+	for _, commit := range c.Commits[:min(max, len(c.Commits))] {
+		lines = append(lines, fmt.Sprintf("%s %s (%s)", commit.SHA, commit.Subject, commit.Author))
+	}
+	// Commits may also have been left out when the changelog was recorded
+	if more := c.Count - len(lines); more > 0 {
+		lines = append(lines, fmt.Sprintf("… and %d more", more))
+	}
+	if c.CompareURL != "" {
+		lines = append(lines, c.CompareURL)
+	}
+	return lines
+}
+
+// logChangelog logs the commits being deployed.
+func logChangelog(log *slog.Logger, c *Changelog) {
+	if c == nil {
+		return
+	}
+	msg := "Deploying changes"
+	if c.Reverted {
+		msg = "Reverting changes"
+	}
+	logStep(log, msg, "changes", c.summary())
+	for _, line := range c.lines(maxChangelogCommits) {
+		log.Info(line, eventKey, eventOutput, "source", "changelog")
+	}
+}
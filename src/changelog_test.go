@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func testCommits(n int) []Commit {
+	var commits []Commit
+	for i := range n {
+		commits = append(commits, Commit{SHA: fmt.Sprintf("c%d", i), Subject: fmt.Sprintf("Change %d", i), Author: "Ada"})
+	}
+	return commits
+}
+
+func TestChangelogLines(t *testing.T) {
+	tests := []struct {
+		name      string
+		changelog Changelog
+		max       int
+		want      []string
+	}{
+		{
+			name:      "under max",
+			changelog: Changelog{Count: 2, Commits: testCommits(2)},
+			max:       5,
+			want:      []string{"c0 Change 0 (Ada)", "c1 Change 1 (Ada)"},
+		},
+		{
+			name:      "over max",
+			changelog: Changelog{Count: 3, Commits: testCommits(3)},
+			max:       2,
+			want:      []string{"c0 Change 0 (Ada)", "c1 Change 1 (Ada)", "… and 1 more"},
+		},
+		{
+			name:      "commits left out when recorded",
+			changelog: Changelog{Count: 60, Commits: testCommits(2)},
+			max:       5,
+			want:      []string{"c0 Change 0 (Ada)", "c1 Change 1 (Ada)", "… and 58 more"},
+		},
+		{
+			name:      "compare URL",
+			changelog: Changelog{Count: 1, Commits: testCommits(1), CompareURL: "https://github.com/acme/web/compare/a...b"},
+			max:       5,
+			want:      []string{"c0 Change 0 (Ada)", "https://github.com/acme/web/compare/a...b"},
+		},
+		{
+			name:      "image without commits",
+			changelog: Changelog{From: "a", To: "b", CompareURL: "https://gitlab.com/acme/web/-/compare/a...b"},
+			max:       5,
+			want:      []string{"https://gitlab.com/acme/web/-/compare/a...b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.changelog.lines(tt.max); !slices.Equal(got, tt.want) {
+				t.Errorf("lines(%d) = %q, want %q", tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChangelogSummary(t *testing.T) {
+	tests := []struct {
+		changelog Changelog
+		want      string
+	}{
+		{Changelog{Count: 1}, "1 commit"},
+		{Changelog{Count: 3}, "3 commits"},
+		{Changelog{Count: 1, Reverted: true}, "1 commit reverted"},
+		{Changelog{Count: 4, Reverted: true}, "4 commits reverted"},
+		{Changelog{From: "0123456789abcdef", To: "fedcba9876543210"}, "0123456789ab...fedcba987654"},
+	}
+	for _, tt := range tests {
+		if got := tt.changelog.summary(); got != tt.want {
+			t.Errorf("%+v summary() = %q, want %q", tt.changelog, got, tt.want)
+		}
+	}
+}
+
+func TestImageChangelog(t *testing.T) {
+	oldLabels := map[string]string{labelRevision: "aaa"}
+	newLabels := map[string]string{labelRevision: "bbb", labelSource: "https://github.com/acme/web.git"}
+
+	c := imageChangelog(oldLabels, newLabels)
+	if c == nil || c.From != "aaa" || c.To != "bbb" || c.CompareURL != "https://github.com/acme/web/compare/aaa...bbb" {
+		t.Errorf("imageChangelog = %+v", c)
+	}
+	if c := imageChangelog(newLabels, newLabels); c != nil {
+		t.Errorf("imageChangelog of the same revision = %+v, want nil", c)
+	}
+	if c := imageChangelog(nil, newLabels); c != nil {
+		t.Errorf("imageChangelog without an old revision = %+v, want nil", c)
+	}
+	if url := compareURL("https://example.com/acme/web", "aaa", "bbb"); url != "" {
+		t.Errorf("compareURL for an unknown host = %q, want none", url)
+	}
+}
+
+func TestGitChangelog(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	t.Setenv("GIT_AUTHOR_NAME", "Ada")
+	t.Setenv("GIT_AUTHOR_EMAIL", "ada@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "Ada")
+	t.Setenv("GIT_COMMITTER_EMAIL", "ada@example.com")
+	git := func(args ...string) string {
+		output, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, output)
+		}
+		return strings.TrimSpace(string(output))
+	}
+	git("init", "-q")
+	var revisions []string
+	for _, subject := range []string{"Initial commit", "Add login", "Fix login"} {
+		git("commit", "-q", "--allow-empty", "-m", subject)
+		revisions = append(revisions, git("rev-parse", "HEAD"))
+	}
+
+	c, err := gitChangelog(dir, revisions[0], revisions[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Count != 2 || c.Reverted || len(c.Commits) != 2 || c.Commits[0].Subject != "Fix login" || c.Commits[0].Author != "Ada" {
+		t.Errorf("forward changelog = %+v", c)
+	}
+
+	c, err = gitChangelog(dir, revisions[2], revisions[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Count != 1 || !c.Reverted || len(c.Commits) != 1 || c.Commits[0].Subject != "Fix login" {
+		t.Errorf("rollback changelog = %+v", c)
+	}
+
+	if c, err := gitChangelog(dir, revisions[1], revisions[1]); c != nil || err != nil {
+		t.Errorf("changelog of the same revision = %+v, %v", c, err)
+	}
+}
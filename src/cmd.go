@@ -2,7 +2,9 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -22,6 +24,21 @@ var listCmd = &cobra.Command{
 			fmt.Println("No projects configured.")
 			return
 		}
+		if resolved, _ := cmd.Flags().GetBool("resolved"); resolved {
+			// The effective settings, with templates, defaults and env files applied
+			for i, p := range config.Projects {
+				out, err := resolvedYAML(p)
+				if err != nil {
+					fmt.Println("Failed to print project:", err)
+					os.Exit(1)
+				}
+				if i > 0 {
+					fmt.Println("---")
+				}
+				fmt.Print(redact(out))
+			}
+			return
+		}
 		fmt.Println("Configured projects:")
 		for _, p := range config.Projects {
 			if p.Type == "image" && p.Image != "" {
@@ -37,31 +54,7 @@ var listCmd = &cobra.Command{
 	},
 }
 
-var initCmd = &cobra.Command{
-	Use:   "init",
-	Short: "Initialize updatectrl configuration and daemon",
-	Run: func(cmd *cobra.Command, args []string) {
-		if runtime.GOOS != "windows" && os.Geteuid() != 0 {
-			fmt.Println("Error: This command requires root privileges on Linux.")
-			fmt.Println("Please run: sudo updatectrl init")
-			os.Exit(1)
-		}
-
-		var configDir, configPath string
-		if runtime.GOOS == "windows" {
-			configDir = filepath.Join(os.Getenv("USERPROFILE"), "updatectrl")
-		} else {
-			configDir = "/etc/updatectrl"
-		}
-		configPath = filepath.Join(configDir, "updatectrl.yaml")
-
-		if err := os.MkdirAll(configDir, 0755); err != nil {
-			fmt.Printf("Failed to create config directory: %v\n", err)
-			os.Exit(1)
-		}
-
-		if _, err := os.Stat(configPath); os.IsNotExist(err) {
-			defaultConfig := []byte(`interval: 600
+const defaultConfig = `interval: 600
 intervalMinutes: 10
 projects:
   # Git-based project with Docker build
@@ -86,15 +79,42 @@ projects:
     image: user/react-dashboard:latest
     port: "3000:80"
     containerName: my-dashboard
-`)
-			if err := os.WriteFile(configPath, defaultConfig, 0644); err != nil {
-				fmt.Printf("Failed to write config file: %v\n", err)
-				os.Exit(1)
-			}
-			fmt.Println("Created config at", configPath)
-		} else {
-			fmt.Println("Config already exists at", configPath)
+`
+
+// writeDefaultConfig creates the example config at path, unless a config
+// already exists there.
+func writeDefaultConfig(path string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		fmt.Printf("Failed to create config directory: %v\n", err)
+		os.Exit(1)
+	}
+	if _, err := os.Stat(path); err == nil {
+		fmt.Println("Config already exists at", path)
+		return
+	}
+	if err := os.WriteFile(path, []byte(defaultConfig), 0600); err != nil {
+		fmt.Printf("Failed to write config file: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("Created config at", path)
+}
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Initialize updatectrl configuration and daemon",
+	Run: func(cmd *cobra.Command, args []string) {
+		if user, _ := cmd.Flags().GetBool("user"); user {
+			initUser()
+			return
 		}
+		if runtime.GOOS != "windows" && os.Geteuid() != 0 {
+			fmt.Println("Error: This command requires root privileges on Linux.")
+			fmt.Println("Please run: sudo updatectrl init")
+			fmt.Println("Or install for your user only: updatectrl init --user")
+			os.Exit(1)
+		}
+
+		writeDefaultConfig(configPath())
 
 		if runtime.GOOS == "windows" {
 			taskName := "updatectrl"
@@ -145,14 +165,15 @@ Description=Updatectrl Daemon - Auto-update your projects
 After=network.target
 
 [Service]
-ExecStart=/usr/local/bin/updatectrl watch
+ExecStart=/usr/local/bin/updatectrl watch%s
+ExecReload=/bin/kill -HUP $MAINPID
 WorkingDirectory=/etc/updatectrl
 Restart=always
 User=%s
 
 [Install]
 WantedBy=multi-user.target
-`, user)
+`, configFlag(), user)
 			if err := os.WriteFile(servicePath, []byte(service), 0644); err != nil {
 				fmt.Printf("Failed to write systemd service file: %v\n", err)
 				os.Exit(1)
@@ -178,6 +199,88 @@ WantedBy=multi-user.target
 	},
 }
 
+// configFlag returns the --config argument the daemon has to be started
+// with, if the config file was chosen explicitly.
+func configFlag() string {
+	if path := explicitConfigPath(); path != "" {
+		abs, _ := filepath.Abs(path)
+		return fmt.Sprintf(" --config %q", abs)
+	}
+	return ""
+}
+
+func userUnitPath() string {
+	return filepath.Join(xdgDir("XDG_CONFIG_HOME", ".config"), "systemd", "user", "updatectrl.service")
+}
+
+// userUnitInstalled reports whether `init --user` installed the daemon as a
+// systemd user service for the current user.
+func userUnitInstalled() bool {
+	if !userMode() {
+		return false
+	}
+	_, err := os.Stat(userUnitPath())
+	return err == nil
+}
+
+// initUser sets updatectrl up for the current user only: a config in
+// ~/.config/updatectrl and a systemd user service, no root required.
+func initUser() {
+	if runtime.GOOS != "linux" {
+		fmt.Println("Error: --user is only supported on Linux with systemd.")
+		os.Exit(1)
+	}
+
+	path := explicitConfigPath()
+	if path == "" {
+		path = userConfigPath()
+	}
+	path, _ = filepath.Abs(path)
+	writeDefaultConfig(path)
+
+	executable, err := os.Executable()
+	if err != nil {
+		fmt.Printf("Failed to locate the updatectrl binary: %v\n", err)
+		os.Exit(1)
+	}
+	service := fmt.Sprintf(`[Unit]
+Description=Updatectrl Daemon - Auto-update your projects
+After=network-online.target
+
+[Service]
+ExecStart=%s watch --config %q
+ExecReload=/bin/kill -HUP $MAINPID
+Restart=always
+
+[Install]
+WantedBy=default.target
+`, executable, path)
+
+	servicePath := userUnitPath()
+	if err := os.MkdirAll(filepath.Dir(servicePath), 0755); err != nil {
+		fmt.Printf("Failed to create systemd user directory: %v\n", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(servicePath, []byte(service), 0644); err != nil {
+		fmt.Printf("Failed to write systemd service file: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("Created systemd user service at", servicePath)
+
+	if err := exec.Command("systemctl", "--user", "daemon-reload").Run(); err != nil {
+		fmt.Printf("Failed to reload systemd user daemon: %v\n", err)
+		os.Exit(1)
+	}
+	if output, err := exec.Command("systemctl", "--user", "enable", "--now", "updatectrl").CombinedOutput(); err != nil {
+		fmt.Printf("Failed to enable and start service: %v\nOutput: %s\n", err, output)
+		os.Exit(1)
+	}
+	fmt.Println("Systemd user service installed and started.")
+	fmt.Println("\nCheck status with: systemctl --user status updatectrl")
+	fmt.Println("View logs with: journalctl --user -u updatectrl -f")
+	fmt.Println("Keep it running after you log out with: loginctl enable-linger", os.Getenv("USER"))
+}
+
 var logsCmd = &cobra.Command{
 	Use:   "logs",
 	Short: "View updatectrl daemon logs",
@@ -195,6 +298,9 @@ var logsCmd = &cobra.Command{
 
 		// Linux - use journalctl
 		journalArgs := []string{"-u", "updatectrl"}
+		if userUnitInstalled() {
+			journalArgs = append([]string{"--user"}, journalArgs...)
+		}
 
 		if follow {
 			journalArgs = append(journalArgs, "-f")
@@ -218,6 +324,8 @@ var logsCmd = &cobra.Command{
 }
 
 func init() {
+	listCmd.Flags().Bool("resolved", false, "Print the effective settings of each project as YAML, with templates and defaults applied")
+	initCmd.Flags().Bool("user", false, "Install for the current user only, with a systemd user service")
 	logsCmd.Flags().BoolP("follow", "f", false, "Follow log output (live tail)")
 	logsCmd.Flags().IntP("lines", "n", 50, "Number of log lines to show")
 }
@@ -226,40 +334,163 @@ var watchCmd = &cobra.Command{
 	Use:   "watch",
 	Short: "Run updatectrl daemon to auto-update projects",
 	Run: func(cmd *cobra.Command, args []string) {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		watch(dryRun)
+	},
+}
+
+// watch runs the update daemon. In dry-run mode it only prints what each
+// cycle would do.
+func watch(dryRun bool) {
+	config := loadConfig()
+	slog.Info("Check interval", "interval", fmt.Sprintf("%ds", config.intervalSeconds()))
+
+	if configFromEnv() {
+		logStep(slog.Default(), "Running in Docker mode - auto-discovering containers")
+	} else if config.discovers() {
+		logStep(slog.Default(), "Auto-discovering containers", "configured", len(config.declared))
+	}
+
+	if err := newDaemon(config, dryRun).run(); err != nil {
+		fmt.Printf("Failed to start daemon: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// runCycle checks the given projects once, skipping paused ones. running is
+// called with the name of each project before it is checked.
+func runCycle(projects []Project, running func(name string)) []UpdateResult {
+	s, err := loadState()
+	if err != nil {
+		slog.Error("Failed to read state", "error", err)
+		s = &State{Projects: map[string]*ProjectState{}}
+	}
+
+	results := []UpdateResult{}
+	for _, p := range projects {
+		if ps, ok := s.Projects[p.Name]; ok && ps.Paused {
+			logSkipped(slog.Default(), "Skipping paused project", projectKey, p.Name)
+			if current, err := localRevision(p); err == nil && current != "" {
+				p.branch = ps.Branch
+				checkAvailable(slog.Default().With(projectKey, p.Name), p, current)
+			}
+			continue
+		}
+		if running != nil {
+			running(p.Name)
+		}
+		logSection(slog.Default(), "Checking", projectKey, p.Name)
+		res, _ := runProject(p)
+		results = append(results, res)
+	}
+	return results
+}
+
+// RunReport summarizes a single `run --once` cycle.
+type RunReport struct {
+	StartedAt time.Time      `json:"startedAt"`
+	Duration  float64        `json:"durationSeconds"`
+	Failed    int            `json:"failed"`
+	Results   []UpdateResult `json:"results"`
+}
+
+var runCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Run the update loop, or a single cycle with --once",
+	Run: func(cmd *cobra.Command, args []string) {
+		once, _ := cmd.Flags().GetBool("once")
+		reportPath, _ := cmd.Flags().GetString("report")
+		if !once {
+			watch(false)
+			return
+		}
+
+		report := RunReport{StartedAt: time.Now()}
 		config := loadConfig()
-		var intervalSeconds int
-		if config.Interval > 0 {
-			intervalSeconds = config.Interval
+		report.Results = runCycle(dueProjects(config.Projects, config.intervalSeconds()), nil)
+		report.Duration = time.Since(report.StartedAt).Seconds()
+		for _, res := range report.Results {
+			if res.Outcome == outcomeFailed || res.Outcome == outcomeSuspended {
+				report.Failed++
+			}
+		}
+
+		data, _ := json.MarshalIndent(report, "", "  ")
+		if reportPath != "" {
+			if err := os.WriteFile(reportPath, append(data, '\n'), 0644); err != nil {
+				fmt.Println("Failed to write report:", err)
+				os.Exit(1)
+			}
 		} else {
-			intervalSeconds = config.IntervalMinutes * 60
+			fmt.Println(string(data))
 		}
-		fmt.Printf("Running updatectrl every %d seconds...\n", intervalSeconds)
 
-		if isRunningInDocker() {
-			fmt.Println("→ Running in Docker mode - auto-discovering containers")
+		if report.Failed > 0 {
+			os.Exit(1)
 		}
+	},
+}
 
-		for {
-			// Reload config each iteration when in Docker mode to pick up new containers
-			if isRunningInDocker() {
-				config = loadConfig()
-			}
+var updateCmd = &cobra.Command{
+	Use:   "update <project...>",
+	Short: "Update one or more projects immediately",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		projects, err := selectProjects(loadConfig(), args)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
 
-			if len(config.Projects) == 0 {
-				fmt.Println("⚠ No projects found to monitor")
+		if dryRun {
+			var actions []PlanAction
+			for _, p := range projects {
+				actions = append(actions, planProject(p))
 			}
+			printPlan(actions)
+			return
+		}
 
-			for _, p := range config.Projects {
-				fmt.Println("\n→ Checking", p.Name)
-				updateProject(p)
+		if daemonRunning() {
+			req := updateRequest{}
+			for _, p := range projects {
+				req.Projects = append(req.Projects, p.Name)
 			}
+			failed, err := updateThroughDaemon(req)
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+			if failed > 0 {
+				os.Exit(1)
+			}
+			return
+		}
 
-			fmt.Printf("\n→ Sleeping for %d seconds...\n", intervalSeconds)
-			time.Sleep(time.Duration(intervalSeconds) * time.Second)
+		failed := 0
+		for _, p := range projects {
+			logSection(slog.Default(), "Updating", projectKey, p.Name)
+			resetBreaker(p.Name)
+			if _, err := runProject(p); err != nil {
+				fmt.Printf("Update failed for %s: %s\n", p.Name, redact(err.Error()))
+				failed++
+			}
+		}
+		if failed > 0 {
+			os.Exit(1)
 		}
 	},
 }
 
+func init() {
+	watchCmd.Flags().Bool("dry-run", false, "Only print what each cycle would do")
+	updateCmd.Flags().Bool("dry-run", false, "Only print what the update would do")
+	runCmd.Flags().Bool("once", false, "Run a single update cycle and exit")
+	runCmd.Flags().String("report", "", "Write the JSON report of --once to this file instead of stdout")
+}
+
 var buildCmd = &cobra.Command{
 	Use:   "build [project-name]",
 	Short: "Run build command for a specific project",
@@ -276,7 +507,7 @@ var buildCmd = &cobra.Command{
 				}
 
 				fmt.Printf("Building project %s...\n", projectName)
-				err := runBuildCommand(p.BuildCommand, p.Path)
+				err := runBuildCommand(p.BuildCommand, p.Path, os.Stdout)
 				if err != nil {
 					fmt.Printf("Build failed for %s: %v\n", projectName, err)
 				} else {
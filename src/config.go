@@ -1,41 +1,196 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
-
-	"gopkg.in/yaml.v3"
+	"strings"
 )
 
-func loadConfig() Config {
-	if isRunningInDocker() {
-		return loadConfigFromEnv()
+const systemConfigPath = "/etc/updatectrl/updatectrl.yaml"
+
+// configFile is set by the global --config flag.
+var configFile string
+
+// explicitConfigPath returns the config file chosen with --config or
+// UPDATECTRL_CONFIG, if any.
+func explicitConfigPath() string {
+	if configFile != "" {
+		return configFile
 	}
+	return os.Getenv("UPDATECTRL_CONFIG")
+}
 
-	var configPath string
+// configPath returns the config file to use. Regular users get
+// ~/.config/updatectrl/updatectrl.yaml, unless they only have access to a
+// system-wide installation.
+func configPath() string {
+	if path := explicitConfigPath(); path != "" {
+		return path
+	}
 	if runtime.GOOS == "windows" {
-		configPath = filepath.Join(os.Getenv("USERPROFILE"), "updatectrl", "updatectrl.yaml")
-	} else {
-		configPath = "/etc/updatectrl/updatectrl.yaml"
+		return filepath.Join(os.Getenv("USERPROFILE"), "updatectrl", "updatectrl.yaml")
+	}
+	if userMode() {
+		user := userConfigPath()
+		if _, err := os.Stat(user); err == nil {
+			return user
+		}
+		if _, err := os.Stat(systemConfigPath); err == nil {
+			return systemConfigPath
+		}
+		return user
 	}
+	return systemConfigPath
+}
 
-	data, err := os.ReadFile(configPath)
-	if err != nil {
-		fmt.Println("Failed to read config:", err)
-		os.Exit(1)
+// userMode reports whether updatectrl runs as a regular user on a Unix
+// system, where it can't use /etc and /var/lib.
+func userMode() bool {
+	return runtime.GOOS != "windows" && os.Geteuid() != 0
+}
+
+// xdgDir returns the XDG base directory set in env, or its default below the
+// home directory.
+func xdgDir(env, fallback string) string {
+	if dir := os.Getenv(env); dir != "" {
+		return dir
 	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, fallback)
+}
+
+func userConfigPath() string {
+	return filepath.Join(xdgDir("XDG_CONFIG_HOME", ".config"), "updatectrl", "updatectrl.yaml")
+}
+
+// configFromEnv reports whether the configuration comes from environment
+// variables and container discovery rather than a file: in Docker, unless a
+// config file was given explicitly or mounted at the default location.
+func configFromEnv() bool {
+	if !isRunningInDocker() || explicitConfigPath() != "" {
+		return false
+	}
+	_, err := os.Stat(configPath())
+	return err != nil
+}
 
-	var c Config
-	if err := yaml.Unmarshal(data, &c); err != nil {
-		fmt.Printf("Failed to parse config: %v\n", err)
+func loadConfig() Config {
+	if configFromEnv() {
+		return loadConfigFromEnv()
+	}
+
+	c, err := readConfig()
+	var configErrs ConfigErrors
+	if errors.As(err, &configErrs) {
+		fmt.Printf("Invalid config %s:\n%v\n", configPath(), err)
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Println("Failed to read config:", err)
 		os.Exit(1)
 	}
+	configureRedaction(c)
 	return c
 }
 
+// readConfig reads and validates the configuration file and its includes,
+// or the configuration in the git repository it points to.
+func readConfig() (Config, error) {
+	c, err := readConfigFile(configPath())
+	if err == nil && c.Source != nil {
+		c, err = readSourceConfig(*c.Source, false)
+	}
+	if err != nil {
+		return Config{}, err
+	}
+
+	c.declared = c.Projects
+	if c.discovers() {
+		c = c.withDiscovered(discoverProjectsFromContainers(c.Discovery))
+	}
+	return c, nil
+}
+
+func readConfigFile(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	return parseConfig(path, data)
+}
+
+// defaultIncludes are always included, so apps can drop their projects into
+// conf.d next to the config without editing it.
+var defaultIncludes = []string{"conf.d/*.yaml", "conf.d/*.yml"}
+
+// includePatterns returns the glob patterns of the files included by the
+// config at path, relative patterns resolved against its directory.
+func includePatterns(path string, include []string) []string {
+	var patterns []string
+	for _, pattern := range append(slices.Clone(defaultIncludes), include...) {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		if !slices.Contains(patterns, pattern) {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// includedFiles returns the files included by the config at path, sorted by
+// name within each pattern. Patterns that match nothing are fine.
+func includedFiles(path string, include []string) ([]string, error) {
+	var files []string
+	for _, pattern := range includePatterns(path, include) {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("include: invalid pattern %q", pattern)
+		}
+		for _, file := range matches {
+			if file != path && !slices.Contains(files, file) {
+				files = append(files, file)
+			}
+		}
+	}
+	return files, nil
+}
+
+// includeFile is the format of included files.
+type includeFile struct {
+	Projects []Project `yaml:"projects"`
+}
+
+// readInclude reads the projects of an included file, the secrets read for
+// them and the unknown keys in it. Only projects may be defined there;
+// everything else belongs in the main config.
+func readInclude(file string) ([]Project, []yamlRef, []string, ConfigErrors, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	data, decrypted, err := decryptFile(file, data)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	var snippet includeFile
+	doc, secrets, unknown, err := decodeStrict(file, filepath.Dir(file), data, &snippet)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	projects := mappingValue(doc, "projects")
+	refs := make([]yamlRef, len(snippet.Projects))
+	for i := range snippet.Projects {
+		refs[i] = yamlRef{file: file, node: sequenceItem(projects, i)}
+	}
+	return snippet.Projects, refs, append(decrypted, secrets...), unknown, nil
+}
+
 func loadConfigFromEnv() Config {
 	config := Config{}
 
@@ -47,8 +202,89 @@ func loadConfigFromEnv() Config {
 		config.Interval = 600 // default 10 minutes
 	}
 
+	config.Webhook.Listen = os.Getenv("UPDATECTL_WEBHOOK_LISTEN")
+	config.Webhook.Secret = os.Getenv("UPDATECTL_WEBHOOK_SECRET")
+	config.Metrics.Listen = os.Getenv("UPDATECTL_METRICS_LISTEN")
+	if url := os.Getenv("UPDATECTL_NOTIFY_URL"); url != "" {
+		n := NotificationConfig{Type: os.Getenv("UPDATECTL_NOTIFY_TYPE"), URL: url}
+		if n.Type == "" {
+			n.Type = "webhook"
+		}
+		if events := os.Getenv("UPDATECTL_NOTIFY_EVENTS"); events != "" {
+			n.Events = strings.Split(events, ",")
+		}
+		config.Notifications = append(config.Notifications, n)
+	}
+
+	config.Discovery.LabelRequired = os.Getenv("UPDATECTL_LABEL_REQUIRED") == "true"
+	config.Discovery.Include = splitList(os.Getenv("UPDATECTL_INCLUDE"))
+	config.Discovery.Exclude = splitList(os.Getenv("UPDATECTL_EXCLUDE"))
+
 	// Auto-discover projects from running containers
-	config.Projects = discoverProjectsFromContainers()
+	config.Projects = discoverProjectsFromContainers(config.Discovery)
 
+	applyDefaults(&config)
+	configureRedaction(config)
 	return config
 }
+
+// intervalSeconds returns the check interval, honoring the deprecated
+// intervalMinutes setting.
+func (c Config) intervalSeconds() int {
+	if c.Interval > 0 {
+		return c.Interval
+	}
+	return c.IntervalMinutes * 60
+}
+
+// applyDefaults fills in unset retry and circuit breaker settings and copies
+// the global values onto projects that don't override them. Global
+// notifications are added to the projects they apply to.
+func applyDefaults(c *Config) {
+	if c.Retry.Attempts <= 0 {
+		c.Retry.Attempts = 3
+	}
+	if c.Retry.Delay <= 0 {
+		c.Retry.Delay = 5
+	}
+	if c.Retry.MaxDelay <= 0 {
+		c.Retry.MaxDelay = 60
+	}
+	if c.CircuitBreaker.Threshold == 0 {
+		c.CircuitBreaker.Threshold = 5
+	}
+
+	for i := range c.Projects {
+		c.projectDefaults(&c.Projects[i])
+	}
+}
+
+// projectDefaults fills in the settings of a project it inherits from the
+// global ones.
+func (c *Config) projectDefaults(p *Project) {
+	if p.Retry == nil {
+		retry := c.Retry
+		p.Retry = &retry
+	} else {
+		if p.Retry.Attempts <= 0 {
+			p.Retry.Attempts = c.Retry.Attempts
+		}
+		if p.Retry.Delay <= 0 {
+			p.Retry.Delay = c.Retry.Delay
+		}
+		if p.Retry.MaxDelay <= 0 {
+			p.Retry.MaxDelay = c.Retry.MaxDelay
+		}
+	}
+	if p.CircuitBreaker == nil {
+		breaker := c.CircuitBreaker
+		p.CircuitBreaker = &breaker
+	} else if p.CircuitBreaker.Threshold == 0 {
+		p.CircuitBreaker.Threshold = c.CircuitBreaker.Threshold
+	}
+	for _, n := range c.Notifications {
+		if len(n.Projects) == 0 || slices.Contains(n.Projects, p.Name) {
+			p.Notifications = append(p.Notifications, n)
+		}
+	}
+}
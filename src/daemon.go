@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// daemon is the long-running update loop started by watch. Its state is
+// shared with the control API, so it is guarded by mu.
+type daemon struct {
+	mu        sync.Mutex
+	config    Config
+	dryRun    bool
+	startedAt time.Time
+	current   string // Project being checked right now
+	nextCycle time.Time
+
+	triggers chan []Project
+	updates  chan updateJob
+	reloaded chan struct{} // Signals a changed interval to the loop
+}
+
+// updateJob is an update requested by the CLI, run by the daemon so the
+// project isn't updated by two processes at once.
+type updateJob struct {
+	projects []Project
+	done     chan []UpdateResult
+}
+
+func newDaemon(config Config, dryRun bool) *daemon {
+	return &daemon{
+		config:    config,
+		dryRun:    dryRun,
+		startedAt: time.Now(),
+		triggers:  make(chan []Project, 16),
+		updates:   make(chan updateJob, 16),
+		reloaded:  make(chan struct{}, 1),
+	}
+}
+
+// run performs a full cycle every interval, and checks projects in between
+// whenever the control API triggers them. It fails right away if another
+// daemon is already running.
+func (d *daemon) run() error {
+	listener, err := listenAPI()
+	switch {
+	case errors.Is(err, errDaemonRunning):
+		return err
+	case err != nil:
+		slog.Error("Failed to start control API", "error", err)
+	default:
+		go d.serveAPI(listener)
+	}
+	if d.config.Webhook.Listen != "" {
+		go d.serveWebhooks(d.config.Webhook)
+	}
+	if d.config.Metrics.Listen != "" {
+		go serveMetrics(d.config.Metrics.Listen)
+	}
+	go d.runDigests()
+	if !configFromEnv() {
+		go d.watchConfig()
+	}
+
+	next := time.Now()
+	var last time.Time
+	for {
+		select {
+		case <-time.After(time.Until(next)):
+			// Reload config each iteration when in Docker mode to pick up new containers
+			if configFromEnv() {
+				config := loadConfig()
+				d.mu.Lock()
+				d.config = config
+				d.mu.Unlock()
+			} else {
+				d.syncSource()
+				d.rediscover()
+			}
+
+			last = time.Now()
+			d.check(dueProjects(d.projects(), d.interval()))
+
+			interval := d.interval()
+			next = time.Now().Add(time.Duration(interval) * time.Second)
+			d.mu.Lock()
+			d.nextCycle = next
+			d.mu.Unlock()
+			logSection(slog.Default(), "Sleeping", "seconds", interval)
+		case projects := <-d.triggers:
+			logSection(slog.Default(), "Triggered check", "projects", len(projects))
+			d.check(projects)
+		case job := <-d.updates:
+			job.done <- d.update(job.projects)
+		case <-d.reloaded:
+			next = last.Add(time.Duration(d.interval()) * time.Second)
+			d.mu.Lock()
+			d.nextCycle = next
+			d.mu.Unlock()
+		}
+	}
+}
+
+func (d *daemon) check(projects []Project) {
+	if d.dryRun {
+		var actions []PlanAction
+		for _, p := range projects {
+			action := planProject(p)
+			notifyAvailable(p, action)
+			actions = append(actions, action)
+		}
+		logSection(slog.Default(), "Dry run, no changes will be made")
+		logPlan(actions)
+		return
+	}
+
+	runCycle(projects, func(name string) {
+		d.mu.Lock()
+		d.current = name
+		d.mu.Unlock()
+	})
+
+	d.mu.Lock()
+	d.current = ""
+	d.mu.Unlock()
+}
+
+// update runs projects right away like the update command: paused projects
+// are updated too, and suspended ones are resumed.
+func (d *daemon) update(projects []Project) []UpdateResult {
+	results := []UpdateResult{}
+	for _, p := range projects {
+		d.mu.Lock()
+		d.current = p.Name
+		d.mu.Unlock()
+
+		logSection(slog.Default(), "Updating on request", projectKey, p.Name)
+		resetBreaker(p.Name)
+		res, _ := runProject(p)
+		results = append(results, res)
+	}
+
+	d.mu.Lock()
+	d.current = ""
+	d.mu.Unlock()
+	return results
+}
+
+// dueProjects leaves out the projects with a schedule whose last check was
+// too recent. Half an interval of slack keeps a schedule that is a multiple
+// of the interval from skipping a cycle because the previous check ran late
+// in its cycle.
+func dueProjects(projects []Project, interval int) []Project {
+	if len(projects) == 0 {
+		slog.Warn("No projects found to monitor")
+	}
+	s, err := loadState()
+	if err != nil {
+		return projects
+	}
+
+	var due []Project
+	for _, p := range projects {
+		if ps, ok := s.Projects[p.Name]; ok && p.Schedule > interval {
+			next := ps.LastCheck.Add(time.Duration(p.Schedule-interval/2) * time.Second)
+			if time.Now().Before(next) {
+				slog.Debug("Not due yet", projectKey, p.Name, "next", formatTime(next))
+				continue
+			}
+		}
+		due = append(due, p)
+	}
+	return due
+}
+
+func (d *daemon) projects() []Project {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.config.Projects
+}
+
+func (d *daemon) interval() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.config.intervalSeconds()
+}
+
+// trigger queues an immediate check of the named projects, or of all
+// projects when names is empty.
+func (d *daemon) trigger(names []string) error {
+	d.mu.Lock()
+	config := d.config
+	d.mu.Unlock()
+
+	projects, err := selectProjects(config, names)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case d.triggers <- projects:
+		return nil
+	default:
+		return fmt.Errorf("too many pending triggers, try again later")
+	}
+}
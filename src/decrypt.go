@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const ageHeader = "-----BEGIN AGE ENCRYPTED FILE-----"
+
+// ageKeyFlag is set by the global --age-key flag.
+var ageKeyFlag string
+
+// ageKeyFile returns the age identity file used to decrypt the config: the
+// one given with --age-key or UPDATECTRL_AGE_KEY_FILE, or age.key next to the
+// config file if it exists.
+func ageKeyFile() string {
+	if ageKeyFlag != "" {
+		return ageKeyFlag
+	}
+	if path := os.Getenv("UPDATECTRL_AGE_KEY_FILE"); path != "" {
+		return path
+	}
+	path := filepath.Join(filepath.Dir(configPath()), "age.key")
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	return ""
+}
+
+// decryptFile decrypts a config file encrypted with sops, returning data
+// unchanged if it isn't. It also returns the decrypted values, which are
+// secrets.
+func decryptFile(path string, data []byte) ([]byte, []string, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil || len(root.Content) == 0 || mappingValue(root.Content[0], "sops") == nil {
+		// Not encrypted; syntax errors are reported when decoding
+		return data, nil, nil
+	}
+
+	cmd := exec.Command("sops", "--decrypt", "--input-type", "yaml", "--output-type", "yaml", path)
+	cmd.Env = os.Environ()
+	if key := ageKeyFile(); key != "" {
+		cmd.Env = append(cmd.Env, "SOPS_AGE_KEY_FILE="+key)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	decrypted, err := cmd.Output()
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, nil, fmt.Errorf("%s is encrypted with sops, which is not installed", path)
+		}
+		return nil, nil, fmt.Errorf("decrypting %s with sops: %s", path, strings.TrimSpace(stderr.String()))
+	}
+
+	var plain yaml.Node
+	if err := yaml.Unmarshal(decrypted, &plain); err != nil {
+		return nil, nil, fmt.Errorf("decrypting %s with sops: %w", path, err)
+	}
+	var secrets []string
+	encryptedValues(&root, &plain, &secrets)
+	return decrypted, secrets, nil
+}
+
+// encryptedValues collects the values of plain that are encrypted in the
+// corresponding sops document.
+func encryptedValues(encrypted, plain *yaml.Node, secrets *[]string) {
+	if encrypted == nil || plain == nil || encrypted.Kind != plain.Kind {
+		return
+	}
+	switch encrypted.Kind {
+	case yaml.ScalarNode:
+		if strings.HasPrefix(encrypted.Value, "ENC[") {
+			*secrets = append(*secrets, plain.Value)
+		}
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for i := range min(len(encrypted.Content), len(plain.Content)) {
+			encryptedValues(encrypted.Content[i], plain.Content[i], secrets)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(plain.Content); i += 2 {
+			encryptedValues(mappingValue(encrypted, plain.Content[i].Value), plain.Content[i+1], secrets)
+		}
+	}
+}
+
+// decryptAge decrypts an armored age value with the age identity file.
+func decryptAge(value string) (string, error) {
+	key := ageKeyFile()
+	if key == "" {
+		return "", errors.New("age encrypted value, but no key file (use --age-key or UPDATECTRL_AGE_KEY_FILE)")
+	}
+
+	cmd := exec.Command("age", "--decrypt", "--identity", key)
+	cmd.Stdin = strings.NewReader(value)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return "", errors.New("age encrypted value, but age is not installed")
+		}
+		return "", fmt.Errorf("decrypting with age: %s", strings.TrimSpace(stderr.String()))
+	}
+	return string(output), nil
+}
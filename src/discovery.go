@@ -0,0 +1,112 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+)
+
+// discovers reports whether running containers are discovered in addition
+// to the configured projects: by default only when running in Docker.
+func (c Config) discovers() bool {
+	if c.Discovery.Enabled != nil {
+		return *c.Discovery.Enabled
+	}
+	return isRunningInDocker()
+}
+
+// withDiscovered returns the configuration with the running containers
+// merged into its projects. A configured project whose container name
+// matches a discovered container takes the container's image, ports and env
+// where it sets none; projects without a type only exist to override such
+// settings and are dropped if their container isn't running.
+func (c Config) withDiscovered(discovered []Project) Config {
+	projects := make([]Project, 0, len(c.declared)+len(discovered))
+	matched := map[string]bool{}
+	for _, p := range c.declared {
+		containerName := p.ContainerName
+		if containerName == "" {
+			containerName = p.Name
+		}
+		i := slices.IndexFunc(discovered, func(d Project) bool { return d.Name == containerName })
+		switch {
+		case i >= 0 && (p.Type == "" || p.Type == "image"):
+			mergeProject(&p, discovered[i])
+			p.ContainerName = containerName
+		case i >= 0:
+			// Configured as a compose, PM2 or static project; not a container to update by image
+		case p.Type == "":
+			logSkipped(slog.Default(), "Container not running", projectKey, p.Name, "container", containerName)
+			continue
+		}
+		if i >= 0 {
+			matched[containerName] = true
+		}
+		projects = append(projects, p)
+	}
+
+	for _, p := range discovered {
+		if matched[p.Name] || slices.ContainsFunc(projects, func(q Project) bool { return q.Name == p.Name }) {
+			continue
+		}
+		bases, err := c.templateChain(p.Extends, nil)
+		if err != nil {
+			slog.Warn("Ignoring the "+labelExtends+" label", "container", p.Name, "error", err)
+		}
+		for _, base := range bases {
+			mergeProject(&p, base)
+		}
+		mergeProject(&p, c.Defaults)
+		c.projectDefaults(&p)
+		projects = append(projects, p)
+	}
+	c.Projects = projects
+	return c
+}
+
+// includes reports whether a container matches the include patterns, by
+// name, image or image repository.
+func (o DiscoveryConfig) includes(name, image string) bool {
+	return matchesAny(name, o.Include) || matchesAny(image, o.Include) || matchesAny(imageRepository(image), o.Include)
+}
+
+// excludes reports whether a container matches the exclude patterns.
+func (o DiscoveryConfig) excludes(name, image string) bool {
+	return matchesAny(name, o.Exclude) || matchesAny(image, o.Exclude) || matchesAny(imageRepository(image), o.Exclude)
+}
+
+// skipReason returns why a container is not discovered, or "" if it is.
+// Opting out by label always wins, then labelRequired and the exclude
+// patterns; opting in by label overrides the include patterns.
+func (o DiscoveryConfig) skipReason(name, image string, labels map[string]string) string {
+	optedIn := labels[labelEnable] == "true"
+	switch {
+	case labels[labelEnable] == "false":
+		return "Skipping opted out container"
+	case o.LabelRequired && !optedIn:
+		return "Skipping container without the " + labelEnable + "=true label"
+	case o.excludes(name, image):
+		return "Skipping excluded container"
+	case len(o.Include) > 0 && !optedIn && !o.includes(name, image):
+		return "Skipping container not included"
+	}
+	return ""
+}
+
+// rediscover merges the containers running now into the configured
+// projects, at the start of every cycle.
+func (d *daemon) rediscover() {
+	d.mu.Lock()
+	config := d.config
+	d.mu.Unlock()
+	if !config.discovers() {
+		return
+	}
+
+	discovered := discoverProjectsFromContainers(config.Discovery)
+	d.mu.Lock()
+	// The config may have been reloaded in the meantime
+	d.config = d.config.withDiscovered(discovered)
+	config = d.config
+	d.mu.Unlock()
+	configureRedaction(config)
+}
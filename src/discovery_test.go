@@ -0,0 +1,123 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestWithDiscovered(t *testing.T) {
+	c := Config{
+		Retry:          RetryConfig{Attempts: 3, Delay: 5, MaxDelay: 60},
+		CircuitBreaker: CircuitBreakerConfig{Threshold: 5},
+		Notifications:  []NotificationConfig{{Type: "slack", URL: "https://hooks.slack.test"}, {Type: "discord", Projects: []string{"site"}}},
+		Defaults:       Project{RestartPolicy: "always", Env: map[string]string{"TZ": "UTC"}},
+		Templates: map[string]Project{
+			"monitored": {Healthcheck: "http://localhost:8080/health", Env: map[string]string{"LOG": "info"}},
+		},
+		declared: []Project{
+			// Overrides the discovered container of the same name
+			{Name: "api", Env: map[string]string{"DEBUG": "1"}, Schedule: 3600},
+			// Overrides a container by its containerName
+			{Name: "frontend", ContainerName: "web", Type: "image", Port: "8080:80"},
+			// Not running, so there's nothing to update
+			{Name: "worker"},
+			// A project built from a checkout that shares its name with a container
+			{Name: "db", Type: "docker", Path: "/srv/db"},
+			{Name: "site", Type: "static", Path: "/srv/site"},
+		},
+	}
+	c.Projects = c.declared
+	discovered := []Project{
+		{Name: "api", Image: "ghcr.io/acme/api:main", Port: "3000:3000", Env: map[string]string{"DEBUG": "0", "PORT": "3000"}},
+		{Name: "web", Image: "nginx:1.27"},
+		{Name: "db", Image: "postgres:16"},
+		{Name: "cache", Image: "redis:7", Extends: templateNames{"monitored"}},
+	}
+
+	got := c.withDiscovered(discovered)
+
+	names := make([]string, len(got.Projects))
+	for i, p := range got.Projects {
+		names[i] = p.Name
+	}
+	if want := []string{"api", "frontend", "db", "site", "cache"}; !slices.Equal(names, want) {
+		t.Fatalf("projects = %q, want %q", names, want)
+	}
+	api, frontend, db, cache := got.Projects[0], got.Projects[1], got.Projects[2], got.Projects[4]
+
+	if api.Image != "ghcr.io/acme/api:main" || api.Port != "3000:3000" || api.ContainerName != "api" || api.Schedule != 3600 {
+		t.Errorf("api was not merged with its container: %+v", api)
+	}
+	if api.Env["DEBUG"] != "1" || api.Env["PORT"] != "3000" {
+		t.Errorf("api env = %v, want the configured DEBUG and the discovered PORT", api.Env)
+	}
+	if frontend.Image != "nginx:1.27" || frontend.Port != "8080:80" || frontend.ContainerName != "web" {
+		t.Errorf("frontend was not merged with the web container: %+v", frontend)
+	}
+	if db.Type != "docker" || db.Image != "" || db.ContainerName != "" {
+		t.Errorf("docker project db was merged with its container: %+v", db)
+	}
+
+	if cache.Image != "redis:7" || cache.Healthcheck != "http://localhost:8080/health" || cache.RestartPolicy != "always" {
+		t.Errorf("cache lacks its template and defaults: %+v", cache)
+	}
+	if cache.Env["LOG"] != "info" || cache.Env["TZ"] != "UTC" {
+		t.Errorf("cache env = %v, want the template and default variables", cache.Env)
+	}
+	if cache.Retry == nil || cache.Retry.Attempts != 3 || cache.CircuitBreaker == nil || cache.CircuitBreaker.Threshold != 5 {
+		t.Errorf("cache lacks the global retry and circuit breaker settings: %+v", cache)
+	}
+	if len(cache.Notifications) != 1 || cache.Notifications[0].Type != "slack" {
+		t.Errorf("cache notifications = %+v, want only the global slack one", cache.Notifications)
+	}
+
+	// Discovery runs again every cycle, from the same declared projects
+	again := got.withDiscovered(discovered[1:])
+	if len(again.Projects) != 4 || again.Projects[0].Name != "frontend" {
+		t.Errorf("rediscovered projects = %+v", again.Projects)
+	}
+	if len(c.declared[0].Env) != 1 {
+		t.Errorf("merging changed the declared env: %v", c.declared[0].Env)
+	}
+}
+
+func TestWithDiscoveredBadTemplate(t *testing.T) {
+	c := Config{Defaults: Project{RestartPolicy: "always"}}
+	got := c.withDiscovered([]Project{{Name: "cache", Image: "redis:7", Extends: templateNames{"missing"}}})
+	if len(got.Projects) != 1 || got.Projects[0].RestartPolicy != "always" {
+		t.Errorf("a container extending an unknown template = %+v, want it kept with the defaults", got.Projects)
+	}
+}
+
+func TestSkipReason(t *testing.T) {
+	optIn := map[string]string{labelEnable: "true"}
+	optOut := map[string]string{labelEnable: "false"}
+	tests := []struct {
+		name   string
+		opts   DiscoveryConfig
+		labels map[string]string
+		want   string
+	}{
+		{name: "discovered by default"},
+		{name: "opted out", labels: optOut, want: "Skipping opted out container"},
+		{name: "opting out wins over include", opts: DiscoveryConfig{Include: []string{"web"}}, labels: optOut, want: "Skipping opted out container"},
+		{name: "label required", opts: DiscoveryConfig{LabelRequired: true}, want: "Skipping container without the updatectrl.enable=true label"},
+		{name: "label required and opted in", opts: DiscoveryConfig{LabelRequired: true}, labels: optIn},
+		{name: "excluded by name", opts: DiscoveryConfig{Exclude: []string{"web"}}, want: "Skipping excluded container"},
+		{name: "excluded by repository glob", opts: DiscoveryConfig{Exclude: []string{"ghcr.io/acme/*"}}, want: "Skipping excluded container"},
+		{name: "exclude wins over opting in", opts: DiscoveryConfig{Exclude: []string{"web"}}, labels: optIn, want: "Skipping excluded container"},
+		{name: "exclude wins over include", opts: DiscoveryConfig{Include: []string{"web"}, Exclude: []string{"ghcr.io/acme/web:*"}}, want: "Skipping excluded container"},
+		{name: "not included", opts: DiscoveryConfig{Include: []string{"api", "redis"}}, want: "Skipping container not included"},
+		{name: "included by image", opts: DiscoveryConfig{Include: []string{"ghcr.io/acme/web:main"}}},
+		{name: "included by repository", opts: DiscoveryConfig{Include: []string{"ghcr.io/acme/web"}}},
+		{name: "included by name ignoring case", opts: DiscoveryConfig{Include: []string{"WEB*"}}},
+		{name: "opting in overrides include", opts: DiscoveryConfig{Include: []string{"api"}}, labels: optIn},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.skipReason("web", "ghcr.io/acme/web:main", tt.labels); got != tt.want {
+				t.Errorf("skipReason = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
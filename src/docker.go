@@ -1,11 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func isRunningInDocker() bool {
@@ -19,18 +24,27 @@ func isRunningInDocker() bool {
 	return strings.Contains(string(data), "docker") || strings.Contains(string(data), "containerd")
 }
 
-func discoverProjectsFromContainers() []Project {
+// Container labels that control discovery.
+const (
+	labelEnable      = "updatectrl.enable"      // true to opt in, false to opt out
+	labelExtends     = "updatectrl.extends"     // Comma-separated templates of the config to apply
+	labelSchedule    = "updatectrl.schedule"    // Seconds or a duration like 6h between checks
+	labelHealthcheck = "updatectrl.healthcheck" // URL that must respond after a deployment
+	labelPolicy      = "updatectrl.policy"      // Restart policy of the recreated container
+)
+
+func discoverProjectsFromContainers(opts DiscoveryConfig) []Project {
 	cmd := exec.Command("docker", "ps", "--format", "{{.Names}}")
 	output, err := cmd.Output()
 	if err != nil {
-		fmt.Println("✘ Failed to list containers:", err)
+		slog.Error("Failed to list containers", "error", err)
 		return nil
 	}
 
 	var projects []Project
 	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
 
-	fmt.Printf("→ Discovering containers from %d running containers\n", len(lines))
+	logStep(slog.Default(), "Discovering containers", "running", len(lines))
 
 	for _, line := range lines {
 		name := strings.TrimSpace(line)
@@ -39,7 +53,7 @@ func discoverProjectsFromContainers() []Project {
 		}
 
 		if strings.Contains(name, "updatectrl") {
-			fmt.Printf("  ⊘ Skipping updatectrl container: %s\n", name)
+			logSkipped(slog.Default(), "Skipping updatectrl container", "container", name)
 			continue
 		}
 
@@ -47,7 +61,7 @@ func discoverProjectsFromContainers() []Project {
 		imageCmd := exec.Command("docker", "inspect", "--format", "{{.Config.Image}}", name)
 		imageOutput, err := imageCmd.Output()
 		if err != nil {
-			fmt.Printf("  ⊘ Failed to inspect container: %s\n", name)
+			logSkipped(slog.Default(), "Failed to inspect container", "container", name)
 			continue
 		}
 		image := strings.TrimSpace(string(imageOutput))
@@ -61,7 +75,13 @@ func discoverProjectsFromContainers() []Project {
 		looksLikeRegistryImage := strings.Contains(image, "/") || strings.Contains(image, ":")
 
 		if !hasValidPrefix && !looksLikeRegistryImage {
-			fmt.Printf("  ⊘ Skipping local image: %s (%s)\n", name, image)
+			logSkipped(slog.Default(), "Skipping local image", "container", name, "image", image)
+			continue
+		}
+
+		labels := getContainerLabels(name)
+		if reason := opts.skipReason(name, image, labels); reason != "" {
+			logSkipped(slog.Default(), reason, "container", name, "image", image)
 			continue
 		}
 
@@ -75,14 +95,62 @@ func discoverProjectsFromContainers() []Project {
 			Port:  ports,
 			Env:   env,
 		}
+		applyLabels(&project, labels)
 		projects = append(projects, project)
-		fmt.Printf("  ✓ Discovered: %s (image: %s, ports: %s, env vars: %d)\n", name, image, ports, len(env))
+		logSuccess(slog.Default(), "Discovered", "container", name, "image", image, "ports", ports, "env", len(env))
 	}
 
-	fmt.Printf("→ Total containers to monitor: %d\n", len(projects))
+	logStep(slog.Default(), "Total containers to monitor", "count", len(projects))
 	return projects
 }
 
+// applyLabels sets the settings of a discovered container given by its
+// labels. Invalid values are ignored with a warning.
+func applyLabels(p *Project, labels map[string]string) {
+	if extends := labels[labelExtends]; extends != "" {
+		p.Extends = splitList(extends)
+	}
+	if schedule := labels[labelSchedule]; schedule != "" {
+		seconds, err := strconv.Atoi(schedule)
+		if err != nil {
+			if d, derr := time.ParseDuration(schedule); derr == nil {
+				seconds, err = int(d.Seconds()), nil
+			}
+		}
+		if err != nil || seconds <= 0 {
+			slog.Warn("Ignoring invalid "+labelSchedule+" label", "container", p.Name, "value", schedule)
+		} else {
+			p.Schedule = seconds
+		}
+	}
+	if healthcheck := labels[labelHealthcheck]; healthcheck != "" {
+		if validHealthcheck(healthcheck) {
+			p.Healthcheck = healthcheck
+		} else {
+			slog.Warn("Ignoring invalid "+labelHealthcheck+" label, expected an http or https URL", "container", p.Name, "value", healthcheck)
+		}
+	}
+	if policy := labels[labelPolicy]; policy != "" {
+		if validRestartPolicy(policy) {
+			p.RestartPolicy = policy
+		} else {
+			slog.Warn("Ignoring invalid "+labelPolicy+" label", "container", p.Name, "value", policy)
+		}
+	}
+}
+
+// splitList splits a comma-separated list, trimming spaces and dropping
+// empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func getContainerPublishedPorts(containerName string) string {
 	// Get the port bindings in a more reliable format
 	cmd := exec.Command("docker", "port", containerName)
@@ -131,6 +199,16 @@ func getContainerPublishedPorts(containerName string) string {
 	return strings.Join(portMappings, " ")
 }
 
+func getContainerLabels(containerName string) map[string]string {
+	output, err := exec.Command("docker", "inspect", "--format", "{{json .Config.Labels}}", containerName).Output()
+	if err != nil {
+		return nil
+	}
+	var labels map[string]string
+	json.Unmarshal(output, &labels)
+	return labels
+}
+
 func getContainerEnv(containerName string) map[string]string {
 	cmd := exec.Command("docker", "inspect", "--format", `{{range .Config.Env}}{{println .}}{{end}}`, containerName)
 	output, err := cmd.Output()
@@ -167,22 +245,51 @@ func getImageDigest(image string) (string, error) {
 	return strings.TrimSpace(string(output)), nil
 }
 
-func pullDockerImage(image string) error {
-	fmt.Println("→ Pulling Docker image:", image)
+// runsImage reports whether a container was created from the local image,
+// which it may not be after a rollback or when the image was pulled by hand.
+// It reports true if either can't be inspected.
+func runsImage(containerName, image string) bool {
+	containerImage, err := exec.Command("docker", "inspect", "-f", "{{.Image}}", containerName).Output()
+	if err != nil {
+		return true
+	}
+	imageID, err := exec.Command("docker", "image", "inspect", "-f", "{{.Id}}", image).Output()
+	if err != nil {
+		return true
+	}
+	return strings.TrimSpace(string(containerImage)) == strings.TrimSpace(string(imageID))
+}
+
+// containerRevision returns the digest of the image a container runs.
+func containerRevision(containerName string) string {
+	output, err := exec.Command("docker", "inspect", "-f", "{{.Image}}", containerName).Output()
+	if err != nil {
+		return ""
+	}
+	digest, err := getImageDigest(strings.TrimSpace(string(output)))
+	if err != nil {
+		return ""
+	}
+	return digestHash(digest)
+}
+
+func pullDockerImage(log *slog.Logger, image string) error {
+	logStep(log, "Pulling Docker image", "image", image)
 	cmd := exec.Command("docker", "pull", image)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	var output bytes.Buffer
+	cmd.Stdout = io.MultiWriter(logWriter(log, "docker"), &output)
+	cmd.Stderr = cmd.Stdout
+	return commandError(cmd.Run(), output.Bytes())
 }
 
-func restartDockerContainer(p Project) error {
+func restartDockerContainer(log *slog.Logger, p Project) error {
 	containerName := p.ContainerName
 	if containerName == "" {
 		containerName = p.Name
 	}
 
 	// Stop and remove old container if it exists
-	fmt.Println("→ Stopping old container:", containerName)
+	logStep(log, "Stopping old container", "container", containerName)
 	stopCmd := exec.Command("docker", "stop", containerName)
 	stopCmd.Run() // Ignore error if container doesn't exist
 
@@ -194,32 +301,36 @@ func restartDockerContainer(p Project) error {
 
 	// Add port mappings if specified (can be space-separated for multiple ports)
 	if p.Port != "" {
-		fmt.Printf("→ Configuring ports: %s\n", p.Port)
+		logStep(log, "Configuring ports", "port", p.Port)
 		portMappings := strings.Fields(p.Port)
 		for _, portMapping := range portMappings {
 			args = append(args, "-p", portMapping)
-			fmt.Printf("  - Port mapping: %s\n", portMapping)
+			log.Debug("Port mapping", "port", portMapping)
 		}
 	}
 
 	// Add environment variables
 	if len(p.Env) > 0 {
-		fmt.Printf("→ Configuring %d environment variables\n", len(p.Env))
+		logStep(log, "Configuring environment variables", "count", len(p.Env))
 	}
 	for key, value := range p.Env {
 		args = append(args, "-e", fmt.Sprintf("%s=%s", key, value))
 	}
 
 	// Add restart policy
-	args = append(args, "--restart", "unless-stopped")
+	policy := p.RestartPolicy
+	if policy == "" {
+		policy = "unless-stopped"
+	}
+	args = append(args, "--restart", policy)
 
 	// Add image
 	args = append(args, p.Image)
 
-	fmt.Printf("→ Starting new container: docker run %s\n", strings.Join(args, " "))
+	logStep(log, "Starting new container", "command", "docker run "+strings.Join(redactEnvArgs(args), " "))
 	cmd := exec.Command("docker", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = logWriter(log, "docker")
+	cmd.Stderr = cmd.Stdout
 	return cmd.Run()
 }
 
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestApplyLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   Project
+	}{
+		{name: "no labels", want: Project{}},
+		{name: "extends", labels: map[string]string{labelExtends: "monitored, nightly,"}, want: Project{Extends: templateNames{"monitored", "nightly"}}},
+		{name: "schedule in seconds", labels: map[string]string{labelSchedule: "3600"}, want: Project{Schedule: 3600}},
+		{name: "schedule as a duration", labels: map[string]string{labelSchedule: "6h"}, want: Project{Schedule: 21600}},
+		{name: "schedule as a compound duration", labels: map[string]string{labelSchedule: "1h30m"}, want: Project{Schedule: 5400}},
+		{name: "invalid schedule", labels: map[string]string{labelSchedule: "daily"}, want: Project{}},
+		{name: "zero schedule", labels: map[string]string{labelSchedule: "0"}, want: Project{}},
+		{name: "negative schedule", labels: map[string]string{labelSchedule: "-5m"}, want: Project{}},
+		{name: "healthcheck", labels: map[string]string{labelHealthcheck: "http://localhost:8080/health"}, want: Project{Healthcheck: "http://localhost:8080/health"}},
+		{name: "healthcheck without a scheme", labels: map[string]string{labelHealthcheck: "localhost:8080/health"}, want: Project{}},
+		{name: "healthcheck with another scheme", labels: map[string]string{labelHealthcheck: "tcp://localhost:8080"}, want: Project{}},
+		{name: "policy", labels: map[string]string{labelPolicy: "always"}, want: Project{RestartPolicy: "always"}},
+		{name: "policy with retries", labels: map[string]string{labelPolicy: "on-failure:5"}, want: Project{RestartPolicy: "on-failure:5"}},
+		{name: "invalid policy", labels: map[string]string{labelPolicy: "sometimes"}, want: Project{}},
+		{name: "unrelated labels", labels: map[string]string{labelEnable: "true", "com.docker.compose.project": "web"}, want: Project{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Project{Name: "web"}
+			applyLabels(&p, tt.labels)
+			tt.want.Name = "web"
+			if !slices.Equal(p.Extends, tt.want.Extends) || p.Schedule != tt.want.Schedule || p.Healthcheck != tt.want.Healthcheck || p.RestartPolicy != tt.want.RestartPolicy {
+				t.Errorf("applyLabels(%v) = %+v, want %+v", tt.labels, p, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"web", []string{"web"}},
+		{"web, api ,ghcr.io/acme/*", []string{"web", "api", "ghcr.io/acme/*"}},
+		{" , web,,", []string{"web"}},
+	}
+	for _, tt := range tests {
+		if got := splitList(tt.in); !slices.Equal(got, tt.want) {
+			t.Errorf("splitList(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
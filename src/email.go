@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sendEmail delivers a plain text message through the SMTP server of cfg.
+func sendEmail(cfg NotificationConfig, subject, body string) error {
+	s := cfg.SMTP
+	if s == nil || s.Host == "" {
+		return fmt.Errorf("smtp.host is required for email notifications")
+	}
+	if len(cfg.To) == 0 {
+		return fmt.Errorf("no recipients configured")
+	}
+	from := s.From
+	if from == "" {
+		from = s.Username
+	}
+
+	port := s.Port
+	if port == 0 {
+		switch s.TLS {
+		case "tls":
+			port = 465
+		case "none":
+			port = 25
+		default:
+			port = 587
+		}
+	}
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(port))
+	tlsConfig := &tls.Config{ServerName: s.Host}
+
+	var c *smtp.Client
+	switch s.TLS {
+	case "tls":
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", addr, tlsConfig)
+		if err != nil {
+			return err
+		}
+		if c, err = smtp.NewClient(conn, s.Host); err != nil {
+			conn.Close()
+			return err
+		}
+	case "starttls", "", "none":
+		conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+		if err != nil {
+			return err
+		}
+		if c, err = smtp.NewClient(conn, s.Host); err != nil {
+			conn.Close()
+			return err
+		}
+		if s.TLS != "none" {
+			if err := c.StartTLS(tlsConfig); err != nil {
+				c.Close()
+				return fmt.Errorf("STARTTLS failed: %w", err)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown smtp.tls mode %q (use starttls, tls or none)", s.TLS)
+	}
+	defer c.Close()
+
+	if s.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, to := range cfg.To {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s rejected: %w", to, err)
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(emailMessage(from, cfg.To, subject, body)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func emailMessage(from string, to []string, subject, body string) []byte {
+	id := make([]byte, 12)
+	rand.Read(id)
+	domain := "updatectrl"
+	if _, d, ok := strings.Cut(from, "@"); ok {
+		domain = d
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&msg)
+	qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n")))
+	qp.Close()
+	return msg.Bytes()
+}
+
+func emailBody(n Notification) string {
+	var b strings.Builder
+	b.WriteString(n.Title + "\n\n")
+	for _, f := range n.facts() {
+		b.WriteString(f[0] + ": " + f[1] + "\n")
+	}
+	if changes := n.changes(); changes != "" {
+		b.WriteString("\nChanges:\n\n" + changes + "\n")
+	}
+	if n.Logs != "" {
+		b.WriteString("\nLast log lines:\n\n" + n.Logs + "\n")
+	}
+	return b.String()
+}
+
+// digestKey identifies an email notification across the projects it was
+// copied onto, so each digest is sent once.
+func digestKey(cfg NotificationConfig) string {
+	return cfg.Digest + " " + strings.Join(cfg.To, ",")
+}
+
+// runDigests sends the daily digest of every email notification that has one
+// configured, at its time of day.
+func (d *daemon) runDigests() {
+	sent := map[string]string{} // Digest key to the date it was last sent
+	for now := range time.Tick(30 * time.Second) {
+		for _, digest := range collectDigests(d.projects()) {
+			at, err := time.Parse("15:04", digest.config.Digest)
+			if err != nil || now.Hour() != at.Hour() || now.Minute() != at.Minute() {
+				continue
+			}
+			key := digestKey(digest.config)
+			today := now.Format(time.DateOnly)
+			if sent[key] == today {
+				continue
+			}
+			sent[key] = today
+			if err := sendDigest(digest.config, digest.projects, now.Add(-24*time.Hour)); err != nil {
+				slog.Warn("Failed to send digest", "to", strings.Join(digest.config.To, ", "), "error", err)
+			} else {
+				logSuccess(slog.Default(), "Sent daily digest", "to", strings.Join(digest.config.To, ", "))
+			}
+		}
+	}
+}
+
+type emailDigest struct {
+	config   NotificationConfig
+	projects []string
+}
+
+// collectDigests groups projects by the email digest they are reported in.
+func collectDigests(projects []Project) []emailDigest {
+	var digests []emailDigest
+	index := map[string]int{}
+	for _, p := range projects {
+		for _, n := range p.Notifications {
+			if n.Type != "email" || n.Digest == "" {
+				continue
+			}
+			key := digestKey(n)
+			i, ok := index[key]
+			if !ok {
+				i = len(digests)
+				index[key] = i
+				digests = append(digests, emailDigest{config: n})
+			}
+			digests[i].projects = append(digests[i].projects, p.Name)
+		}
+	}
+	return digests
+}
+
+// sendDigest mails a summary of the deployments of projects since the given
+// time.
+func sendDigest(cfg NotificationConfig, projects []string, since time.Time) error {
+	s, err := loadState()
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Updatectrl activity since %s\n", formatTime(since))
+	deployed, failed := 0, 0
+	for _, name := range projects {
+		b.WriteString("\n" + name + "\n")
+		ps := s.Projects[name]
+		if ps != nil && ps.Paused {
+			b.WriteString("  Paused\n")
+		}
+
+		entries := 0
+		history := s.projectHistory(name, false)
+		slices.Reverse(history)
+		for _, h := range history {
+			if h.StartedAt.Before(since) {
+				continue
+			}
+			entries++
+			line := fmt.Sprintf("  %s  %-11s %s", formatTime(h.StartedAt), h.Outcome, shortRevision(h.NewRevision))
+			if h.OldRevision != "" && h.OldRevision != h.NewRevision {
+				line = fmt.Sprintf("  %s  %-11s %s → %s", formatTime(h.StartedAt), h.Outcome, shortRevision(h.OldRevision), shortRevision(h.NewRevision))
+			}
+			if h.Changelog != nil {
+				line += "  " + h.Changelog.summary()
+			}
+			if h.Error != "" {
+				line += "  (" + h.Error + ")"
+			}
+			b.WriteString(line + "\n")
+			switch h.Outcome {
+			case outcomeFailed:
+				failed++
+			default:
+				deployed++
+			}
+		}
+		if entries == 0 {
+			b.WriteString("  No changes\n")
+		}
+	}
+
+	subject := fmt.Sprintf("updatectrl daily digest: %d deployed, %d failed", deployed, failed)
+	return sendEmail(cfg, subject, redact(b.String()))
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"mime/quotedprintable"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpServer is a stand-in SMTP server that accepts one session and records
+// the commands and message it receives.
+type smtpServer struct {
+	listener net.Listener
+	commands []string
+	message  string
+	done     chan struct{}
+}
+
+func startSMTPServer(t *testing.T, rejectRcpt string) *smtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{listener: listener, done: make(chan struct{})}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		defer close(s.done)
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			s.commands = append(s.commands, line)
+			verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch {
+			case verb == "EHLO":
+				reply("250-localhost")
+				reply("250 AUTH PLAIN")
+			case verb == "AUTH":
+				reply("235 Authenticated")
+			case verb == "RCPT" && rejectRcpt != "" && strings.Contains(line, rejectRcpt):
+				reply("550 No such user")
+			case verb == "DATA":
+				reply("354 Go ahead")
+				var msg strings.Builder
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					msg.WriteString(line)
+				}
+				s.message = msg.String()
+				reply("250 Queued")
+			case verb == "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) config() NotificationConfig {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return NotificationConfig{
+		Type: "email",
+		SMTP: &SMTPConfig{Host: host, Port: p, TLS: "none", Username: "updatectrl@example.com", Password: "secret"},
+		To:   []string{"ops@example.com", "dev@example.com"},
+	}
+}
+
+func TestSendEmail(t *testing.T) {
+	server := startSMTPServer(t, "")
+	if err := sendEmail(server.config(), "web failed at build", "Project: web\nError: exit status 1"); err != nil {
+		t.Fatal(err)
+	}
+	<-server.done
+
+	want := []string{
+		"MAIL FROM:<updatectrl@example.com>",
+		"RCPT TO:<ops@example.com>",
+		"RCPT TO:<dev@example.com>",
+		"DATA",
+		"QUIT",
+	}
+	var got []string
+	for _, c := range server.commands {
+		if strings.HasPrefix(c, "AUTH PLAIN ") {
+			auth, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(c, "AUTH PLAIN "))
+			if string(auth) != "\x00updatectrl@example.com\x00secret" {
+				t.Errorf("AUTH PLAIN sent %q", auth)
+			}
+			continue
+		}
+		if !strings.HasPrefix(c, "EHLO") && !strings.HasPrefix(c, "HELO") {
+			got = append(got, c)
+		}
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("commands:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	headers, body, _ := strings.Cut(server.message, "\r\n\r\n")
+	for _, header := range []string{
+		"From: updatectrl@example.com\r\n",
+		"To: ops@example.com, dev@example.com\r\n",
+		"Subject: web failed at build\r\n",
+		"Content-Type: text/plain; charset=utf-8\r\n",
+		"@example.com>\r\n",
+	} {
+		if !strings.Contains(headers+"\r\n", header) {
+			t.Errorf("message headers lack %q:\n%s", header, headers)
+		}
+	}
+	decoded, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != "Project: web\r\nError: exit status 1\r\n" {
+		t.Errorf("message body = %q", decoded)
+	}
+}
+
+func TestSendEmailRejectedRecipient(t *testing.T) {
+	server := startSMTPServer(t, "dev@")
+	err := sendEmail(server.config(), "subject", "body")
+	if err == nil || !strings.Contains(err.Error(), "recipient dev@example.com rejected") {
+		t.Errorf("expected the rejected recipient to fail, got %v", err)
+	}
+}
+
+func TestSendEmailConfigErrors(t *testing.T) {
+	tests := []struct {
+		cfg  NotificationConfig
+		want string
+	}{
+		{NotificationConfig{To: []string{"ops@example.com"}}, "smtp.host is required"},
+		{NotificationConfig{SMTP: &SMTPConfig{Host: "localhost"}}, "no recipients"},
+		{NotificationConfig{SMTP: &SMTPConfig{Host: "localhost", TLS: "ssl"}, To: []string{"ops@example.com"}}, "unknown smtp.tls mode"},
+	}
+	for _, tt := range tests {
+		if err := sendEmail(tt.cfg, "subject", "body"); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("sendEmail(%+v) = %v, want an error containing %q", tt.cfg, err, tt.want)
+		}
+	}
+}
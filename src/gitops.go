@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// checkout returns where the config repository is cloned, one directory
+// per repository and branch.
+func (s SourceConfig) checkout() string {
+	sum := sha256.Sum256([]byte(s.Repo + "#" + s.Branch))
+	return filepath.Join(stateDir(), "config", hex.EncodeToString(sum[:])[:12])
+}
+
+// file returns the config file in the checkout.
+func (s SourceConfig) file() string {
+	path := s.Path
+	if path == "" {
+		path = "updatectrl.yaml"
+	}
+	return filepath.Join(s.checkout(), filepath.FromSlash(path))
+}
+
+// syncSource clones the config repository if it isn't yet, or, with fetch,
+// updates the checkout to the latest commit of the branch. It returns the
+// checked out revision.
+func syncSource(s SourceConfig, fetch bool) (string, error) {
+	dir := s.checkout()
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		if err := os.MkdirAll(filepath.Dir(dir), 0700); err != nil {
+			return "", err
+		}
+		args := []string{"clone", "--single-branch"}
+		if s.Branch != "" {
+			args = append(args, "--branch", s.Branch)
+		}
+		if err := runGit(append(args, s.Repo, dir)...); err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("clone %s: %w", redact(s.Repo), err)
+		}
+	} else if fetch {
+		if err := runGit("-C", dir, "fetch", "--quiet", "origin"); err != nil {
+			return "", fmt.Errorf("fetch %s: %w", redact(s.Repo), err)
+		}
+		// The checkout is only ever read, so local changes can be discarded
+		if err := runGit("-C", dir, "reset", "--quiet", "--hard", "@{upstream}"); err != nil {
+			return "", err
+		}
+	}
+	return gitRevision(dir)
+}
+
+// runGit runs a git command, returning its error output on failure.
+func runGit(args ...string) error {
+	cmd := exec.Command("git", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s", redact(msg))
+		}
+		return err
+	}
+	return nil
+}
+
+// readSourceConfig reads the configuration from the checkout of the config
+// repository, cloning it first if needed, and fetching the latest commit
+// with fetch.
+func readSourceConfig(s SourceConfig, fetch bool) (Config, error) {
+	revision, err := syncSource(s, fetch)
+	if err != nil {
+		return Config{}, err
+	}
+	c, err := readConfigFile(s.file())
+	if err != nil {
+		return Config{}, err
+	}
+	if c.Source != nil {
+		return Config{}, fmt.Errorf("%s: source can only be set in the local config", s.Path)
+	}
+	c.Source = &s
+	c.revision = revision
+	return c, nil
+}
+
+// syncSource pulls the config repository, if the config comes from one, and
+// reloads the configuration when it moved to another revision. It runs at
+// the start of a cycle, which then checks all projects anyway.
+func (d *daemon) syncSource() {
+	d.mu.Lock()
+	source, revision := d.config.Source, d.config.revision
+	d.mu.Unlock()
+	if source == nil {
+		return
+	}
+
+	latest, err := syncSource(*source, true)
+	if err != nil {
+		slog.Warn("Could not update the config repository", "error", err)
+		return
+	}
+	if latest == revision {
+		return
+	}
+	logSection(slog.Default(), "Config repository changed, reloading", "revision", shortRevision(latest))
+	d.reload(false)
+}
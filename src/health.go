@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// healthcheckTimeout is how long a deployed project has to pass its
+// healthcheck.
+const healthcheckTimeout = 60 * time.Second
+
+// waitHealthy polls the healthcheck URL of a project after it was deployed
+// until it responds with a success or redirect status, or the timeout passes.
+func waitHealthy(log *slog.Logger, p Project) error {
+	if p.Healthcheck == "" {
+		return nil
+	}
+
+	logStep(log, "Waiting for healthcheck", "url", p.Healthcheck)
+	client := &http.Client{Timeout: 5 * time.Second}
+	deadline := time.Now().Add(healthcheckTimeout)
+	for {
+		resp, err := client.Get(p.Healthcheck)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 400 {
+				logSuccess(log, "Healthcheck passed")
+				return nil
+			}
+			err = fmt.Errorf("%s returned %s", p.Healthcheck, resp.Status)
+		}
+		if time.Now().After(deadline) {
+			log.Error("Healthcheck failed", "error", err)
+			return err
+		}
+		log.Debug("Healthcheck not passing yet", "error", err)
+		time.Sleep(2 * time.Second)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var digestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+func findProject(config Config, name string) (Project, bool) {
+	for _, p := range config.Projects {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Project{}, false
+}
+
+// rollbackTarget picks the revision to roll back to. Without an explicit
+// revision it uses the one that was running before the current deployment.
+func rollbackTarget(p Project, s *State, to string) (string, error) {
+	history := s.projectHistory(p.Name, false)
+
+	if to == "" {
+		current := ""
+		if ps, ok := s.Projects[p.Name]; ok {
+			current = ps.Revision
+		}
+		for _, h := range history {
+			if h.Outcome != outcomeDeployed && h.Outcome != outcomeRolledBack {
+				continue
+			}
+			if h.OldRevision != "" && h.OldRevision != current {
+				return h.OldRevision, nil
+			}
+		}
+		return "", fmt.Errorf("no previous deployment recorded for %s", p.Name)
+	}
+
+	// Accept abbreviated revisions that appear in the history
+	for _, h := range history {
+		for _, rev := range []string{h.OldRevision, h.NewRevision} {
+			if rev != "" && (strings.HasPrefix(rev, to) || strings.HasPrefix(strings.TrimPrefix(rev, "sha256:"), to)) {
+				return rev, nil
+			}
+		}
+	}
+
+	if p.Type == "image" {
+		if !strings.HasPrefix(to, "sha256:") {
+			to = "sha256:" + to
+		}
+		if !digestPattern.MatchString(to) {
+			return "", fmt.Errorf("%s is not a full image digest", to)
+		}
+		return to, nil
+	}
+
+	exec.Command("git", "-C", p.Path, "fetch", "--quiet").Run()
+	output, err := exec.Command("git", "-C", p.Path, "rev-parse", "--verify", "--quiet", to+"^{commit}").Output()
+	if err != nil {
+		return "", fmt.Errorf("unknown commit %s", to)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history [project]",
+	Short: "List past deployments",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		limit, _ := cmd.Flags().GetInt("lines")
+		output, _ := cmd.Flags().GetString("output")
+		checks, _ := cmd.Flags().GetBool("checks")
+
+		name := ""
+		if len(args) == 1 {
+			name = args[0]
+		}
+
+		s, err := loadState()
+		if err != nil {
+			fmt.Println("Failed to read state:", err)
+			os.Exit(1)
+		}
+		history := s.projectHistory(name, checks)
+		if limit > 0 && len(history) > limit {
+			history = history[:limit]
+		}
+
+		if output == "json" {
+			if history == nil {
+				history = []UpdateResult{}
+			}
+			data, _ := json.MarshalIndent(history, "", "  ")
+			fmt.Println(string(data))
+			return
+		}
+
+		if len(history) == 0 {
+			fmt.Println("No deployments recorded.")
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tPROJECT\tOUTCOME\tFROM\tTO\tCHANGES\tDURATION\tERROR")
+		for _, h := range history {
+			from, to := shortRevision(h.OldRevision), shortRevision(h.NewRevision)
+			if from == "" {
+				from = "-"
+			}
+			if to == "" {
+				to = "-"
+			}
+			changes := "-"
+			if h.Changelog != nil {
+				changes = h.Changelog.summary()
+			}
+			errMsg := "-"
+			if h.Error != "" {
+				errMsg = fmt.Sprintf("%s: %s", h.Stage, h.Error)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%.1fs\t%s\n", formatTime(h.StartedAt), h.Project, h.Outcome, from, to, changes, h.Duration, errMsg)
+		}
+		w.Flush()
+	},
+}
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback <project>",
+	Short: "Redeploy a previous revision and pin the project to it",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		to, _ := cmd.Flags().GetString("to")
+		config := loadConfig()
+
+		p, ok := findProject(config, args[0])
+		if !ok {
+			fmt.Printf("Project %s not found in configuration\n", args[0])
+			os.Exit(1)
+		}
+
+		s, err := loadState()
+		if err != nil {
+			fmt.Println("Failed to read state:", err)
+			os.Exit(1)
+		}
+		target, err := rollbackTarget(p, s, to)
+		if err != nil {
+			fmt.Println("Cannot roll back:", err)
+			os.Exit(1)
+		}
+
+		branch := ""
+		if p.Type != "image" {
+			output, err := exec.Command("git", "-C", p.Path, "rev-parse", "--abbrev-ref", "HEAD").Output()
+			if err == nil && strings.TrimSpace(string(output)) != "HEAD" {
+				branch = strings.TrimSpace(string(output))
+			}
+		}
+
+		err = updateState(func(s *State) {
+			ps := s.project(p.Name)
+			ps.Pinned = target
+			if branch != "" {
+				ps.Branch = branch
+			}
+		})
+		if err != nil {
+			fmt.Println("Failed to save state:", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("→ Rolling back %s to %s\n", p.Name, shortRevision(target))
+		if daemonRunning() {
+			// The daemon picks up the pin from the state
+			failed, err := updateThroughDaemon(updateRequest{Projects: []string{p.Name}})
+			if err != nil || failed > 0 {
+				if err != nil {
+					fmt.Printf("Rollback failed for %s: %v\n", p.Name, err)
+				}
+				os.Exit(1)
+			}
+		} else {
+			resetBreaker(p.Name)
+			if _, err := runProject(p); err != nil {
+				fmt.Printf("Rollback failed for %s: %v\n", p.Name, err)
+				os.Exit(1)
+			}
+		}
+		fmt.Printf("%s is pinned to %s. Run `updatectrl unpin %s` to resume updates.\n", p.Name, shortRevision(target), p.Name)
+	},
+}
+
+var unpinCmd = &cobra.Command{
+	Use:   "unpin <project>",
+	Short: "Resume updates for a project pinned by rollback",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		wasPinned := false
+		err := updateState(func(s *State) {
+			if ps, ok := s.Projects[name]; ok && ps.Pinned != "" {
+				wasPinned = true
+				ps.Pinned = ""
+			}
+		})
+		if err != nil {
+			fmt.Println("Failed to save state:", err)
+			os.Exit(1)
+		}
+		if !wasPinned {
+			fmt.Printf("Project %s is not pinned\n", name)
+			return
+		}
+		fmt.Printf("Unpinned %s, it will be updated on the next check\n", name)
+	},
+}
+
+func init() {
+	historyCmd.Flags().IntP("lines", "n", 20, "Number of deployments to show (0 for all)")
+	historyCmd.Flags().StringP("output", "o", "table", "Output format (table or json)")
+	historyCmd.Flags().Bool("checks", false, "Also show checks that found nothing to deploy")
+	rollbackCmd.Flags().String("to", "", "Revision to roll back to (git SHA or image digest, defaults to the previous deployment)")
+}
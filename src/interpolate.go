@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// interpolator resolves ${VAR} and valueFrom references and decrypts age
+// encrypted values in a configuration file, so secrets don't have to be
+// written into it in plain text.
+type interpolator struct {
+	file    string // Included file, empty for the main file; used in errors
+	dir     string // Directory relative references are resolved against
+	secrets []string
+	errs    ConfigErrors
+}
+
+// interpolate resolves the references in the values below n, in place. It
+// returns the values read from files or decrypted, which are treated as
+// secrets.
+func interpolate(file, dir string, n *yaml.Node) ([]string, ConfigErrors) {
+	in := &interpolator{file: file, dir: dir}
+	in.walk(n)
+	return in.secrets, in.errs
+}
+
+func (in *interpolator) add(n *yaml.Node, format string, args ...any) {
+	in.errs = append(in.errs, ConfigError{File: in.file, Line: n.Line, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (in *interpolator) walk(n *yaml.Node) {
+	switch n.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range n.Content {
+			in.value(child)
+		}
+	case yaml.MappingNode:
+		// Keys are left alone, and so are build commands: the shell expands
+		// their ${VAR}s when they run
+		for i := 1; i < len(n.Content); i += 2 {
+			if n.Content[i-1].Value == "buildCommand" {
+				continue
+			}
+			in.value(n.Content[i])
+		}
+	}
+}
+
+// value resolves a value: a scalar with ${VAR} references, a
+// {valueFrom: file:<path>} mapping, or a collection holding either.
+func (in *interpolator) value(n *yaml.Node) {
+	if from := mappingValue(n, "valueFrom"); from != nil && len(n.Content) == 2 {
+		in.valueFrom(n, from)
+		return
+	}
+	if n.Kind != yaml.ScalarNode {
+		in.walk(n)
+		return
+	}
+	if strings.HasPrefix(strings.TrimSpace(n.Value), ageHeader) {
+		value, err := decryptAge(n.Value)
+		if err != nil {
+			in.add(n, "%v", err)
+			return
+		}
+		n.Value, n.Tag = strings.TrimRight(value, "\r\n"), "!!str"
+		in.secrets = append(in.secrets, n.Value)
+		return
+	}
+	if !strings.Contains(n.Value, "${") {
+		return
+	}
+
+	value, err := expandEnv(n.Value)
+	if err != nil {
+		in.add(n, "%v", err)
+		return
+	}
+	n.Value = value
+	if n.Style == 0 {
+		// Let the decoder resolve the type again, e.g. for interval: ${INTERVAL}
+		n.Tag = ""
+	}
+}
+
+// valueFrom replaces n with the contents of the file it references.
+func (in *interpolator) valueFrom(n, from *yaml.Node) {
+	if from.Kind != yaml.ScalarNode {
+		in.add(from, "valueFrom: expected a reference like file:/run/secrets/name")
+		return
+	}
+	ref, err := expandEnv(from.Value)
+	if err != nil {
+		in.add(from, "%v", err)
+		return
+	}
+	path, ok := strings.CutPrefix(ref, "file:")
+	if !ok || path == "" {
+		in.add(from, "valueFrom: unsupported reference %q, use file:<path>", ref)
+		return
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(in.dir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		in.add(from, "valueFrom: %v", err)
+		return
+	}
+
+	value := string(data)
+	if strings.HasPrefix(value, ageHeader) {
+		if value, err = decryptAge(value); err != nil {
+			in.add(from, "valueFrom: %v", err)
+			return
+		}
+	}
+	// Secret files usually end with a newline that isn't part of the secret
+	value = strings.TrimRight(value, "\r\n")
+	*n = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value, Line: n.Line, Column: n.Column}
+	in.secrets = append(in.secrets, value)
+}
+
+// expandEnv replaces ${VAR} and ${VAR:-default} with the value of the
+// environment variable VAR, or default if it is unset or empty. $${ is a
+// literal ${. Other uses of $, such as $VAR in commands, are left as is.
+func expandEnv(s string) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i-1] + "${")
+			s = s[i+2:]
+			continue
+		}
+		b.WriteString(s[:i])
+
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated variable reference in %q", s)
+		}
+		ref := s[i+2 : i+end]
+		s = s[i+end+1:]
+
+		name, fallback, hasDefault := strings.Cut(ref, ":-")
+		if !validEnvName(name) {
+			return "", fmt.Errorf("invalid variable name %q", name)
+		}
+		value := os.Getenv(name)
+		switch {
+		case value != "":
+		case hasDefault:
+			value = fallback
+		default:
+			if _, ok := os.LookupEnv(name); !ok {
+				return "", fmt.Errorf("environment variable %s is not set", name)
+			}
+		}
+		b.WriteString(value)
+	}
+}
+
+func validEnvName(name string) bool {
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		return false
+	}
+	for _, c := range name {
+		if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// readEnvFile reads a file of KEY=value lines, as used by Docker and
+// dotenv. Blank lines, # comments and an export prefix are ignored, and
+// quotes around values are removed.
+func readEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	env := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		text = strings.TrimPrefix(text, "export ")
+		key, value, ok := strings.Cut(text, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !validEnvName(key) {
+			return nil, fmt.Errorf("%s:%d: expected KEY=value", path, line)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		env[key] = value
+	}
+	return env, scanner.Err()
+}
+
+// loadEnvFiles merges the envFile of each project into its env. Variables
+// set in env take precedence over the file.
+func loadEnvFiles(c *Config, src configSource) ConfigErrors {
+	var errs ConfigErrors
+	for i := range c.Projects {
+		p := &c.Projects[i]
+		if p.EnvFile == "" {
+			continue
+		}
+		ref := src.project(i)
+		path := p.EnvFile
+		if !filepath.IsAbs(path) {
+			dir := filepath.Dir(src.file)
+			if ref.file != "" {
+				dir = filepath.Dir(ref.file)
+			}
+			path = filepath.Join(dir, path)
+		}
+
+		env, err := readEnvFile(path)
+		if err != nil {
+			errs = append(errs, ConfigError{File: ref.file, Line: lineOf(ref.node, "envFile"), Msg: fmt.Sprintf("project %s: envFile: %v", p.Name, err)})
+			continue
+		}
+		for key, value := range p.Env {
+			env[key] = value
+		}
+		p.Env = env
+	}
+	return errs
+}
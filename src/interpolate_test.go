@@ -0,0 +1,185 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("UC_TEST_HOST", "example.com")
+	t.Setenv("UC_TEST_EMPTY", "")
+
+	tests := []struct {
+		in      string
+		want    string
+		wantErr string
+	}{
+		{in: "no references", want: "no references"},
+		{in: "https://${UC_TEST_HOST}/hook", want: "https://example.com/hook"},
+		{in: "${UC_TEST_HOST}${UC_TEST_HOST}", want: "example.comexample.com"},
+		{in: "${UC_TEST_UNSET:-fallback}", want: "fallback"},
+		{in: "${UC_TEST_EMPTY:-fallback}", want: "fallback"},
+		{in: "${UC_TEST_HOST:-fallback}", want: "example.com"},
+		{in: "[${UC_TEST_EMPTY}]", want: "[]"},
+		{in: "${UC_TEST_UNSET:-}", want: ""},
+		{in: "$${UC_TEST_HOST}", want: "${UC_TEST_HOST}"},
+		{in: "echo $HOME $1", want: "echo $HOME $1"},
+		{in: "${UC_TEST_UNSET}", wantErr: "environment variable UC_TEST_UNSET is not set"},
+		{in: "${1ST}", wantErr: `invalid variable name "1ST"`},
+		{in: "${UC-TEST}", wantErr: `invalid variable name "UC-TEST"`},
+		{in: "${}", wantErr: `invalid variable name ""`},
+		{in: "url: ${UC_TEST_HOST", wantErr: "unterminated variable reference"},
+	}
+	for _, tt := range tests {
+		got, err := expandEnv(tt.in)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expandEnv(%q) error = %v, want %q", tt.in, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expandEnv(%q): %v", tt.in, err)
+		} else if got != tt.want {
+			t.Errorf("expandEnv(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestReadEnvFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name: "values",
+			content: `# database settings
+DB_HOST=db.internal
+export DB_PORT=5432
+
+DB_USER = app
+DB_PASSWORD="p@ss word"
+DB_NAME='app'
+DB_URL=postgres://app@db/app?sslmode=disable
+EMPTY=
+`,
+			want: map[string]string{
+				"DB_HOST":     "db.internal",
+				"DB_PORT":     "5432",
+				"DB_USER":     "app",
+				"DB_PASSWORD": "p@ss word",
+				"DB_NAME":     "app",
+				"DB_URL":      "postgres://app@db/app?sslmode=disable",
+				"EMPTY":       "",
+			},
+		},
+		{
+			name:    "mismatched quotes are kept",
+			content: `QUOTED="value'`,
+			want:    map[string]string{"QUOTED": `"value'`},
+		},
+		{
+			name:    "line without a value",
+			content: "DB_HOST=db\nDB_PORT\n",
+			wantErr: ":2: expected KEY=value",
+		},
+		{
+			name:    "invalid key",
+			content: "DB-HOST=db\n",
+			wantErr: ":1: expected KEY=value",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), ".env")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readEnvFile(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasSuffix(err.Error(), tt.wantErr) {
+					t.Errorf("readEnvFile error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("readEnvFile = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := readEnvFile(filepath.Join(t.TempDir(), "missing.env")); !os.IsNotExist(err) {
+		t.Errorf("readEnvFile of a missing file = %v, want a not exist error", err)
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	t.Setenv("UC_TEST_BRANCH", "main")
+	t.Setenv("UC_TEST_INTERVAL", "10")
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "token"), []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var n yaml.Node
+	err := yaml.Unmarshal([]byte(`
+intervalMinutes: ${UC_TEST_INTERVAL}
+projects:
+  - name: web
+    branch: ${UC_TEST_BRANCH}
+    buildCommand: npm run build -- --out ${OUT_DIR}
+    env:
+      TOKEN:
+        valueFrom: file:token
+`), &n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets, errs := interpolate("", dir, &n)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if len(secrets) != 1 || secrets[0] != "s3cret" {
+		t.Errorf("secrets = %q, want the token", secrets)
+	}
+
+	var c struct {
+		IntervalMinutes int `yaml:"intervalMinutes"`
+		Projects        []struct {
+			Branch       string            `yaml:"branch"`
+			BuildCommand string            `yaml:"buildCommand"`
+			Env          map[string]string `yaml:"env"`
+		} `yaml:"projects"`
+	}
+	if err := n.Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	p := c.Projects[0]
+	if c.IntervalMinutes != 10 || p.Branch != "main" || p.Env["TOKEN"] != "s3cret" {
+		t.Errorf("interpolated config = %+v", c)
+	}
+	if p.BuildCommand != "npm run build -- --out ${OUT_DIR}" {
+		t.Errorf("buildCommand = %q, want it left to the shell", p.BuildCommand)
+	}
+}
+
+func TestInterpolateErrors(t *testing.T) {
+	var n yaml.Node
+	if err := yaml.Unmarshal([]byte("projects:\n  - name: web\n    branch: ${UC_TEST_UNSET}\n"), &n); err != nil {
+		t.Fatal(err)
+	}
+	_, errs := interpolate("conf.d/web.yaml", t.TempDir(), &n)
+	if len(errs) != 1 || errs[0].File != "conf.d/web.yaml" || errs[0].Line != 3 || !strings.Contains(errs[0].Msg, "UC_TEST_UNSET is not set") {
+		t.Errorf("errors = %+v", errs)
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Every log record may carry an event attribute that the text handler
+// renders as the familiar prefix symbols.
+const (
+	eventKey       = "event"
+	eventStep      = "step"      // → something is being done
+	eventSuccess   = "success"   // ✓ it worked
+	eventUnchanged = "unchanged" // ● nothing to do
+	eventSkipped   = "skipped"   // ⊘ deliberately not done
+	eventSection   = "section"   // → start of a new block, preceded by a blank line
+	eventOutput    = "output"    // Raw output of git, docker or build commands
+)
+
+// Attributes that identify a project run. The text handler only shows them
+// with --plain, as otherwise the "Checking" headers already show which
+// project a line belongs to.
+const (
+	projectKey = "project"
+	runKey     = "run"
+)
+
+// LogOptions are set from the global --log-* flags.
+type LogOptions struct {
+	Format string // text or json
+	Level  string // debug, info, warn or error
+	Plain  bool   // No emoji and no color in text output
+}
+
+// setupLogging installs the default logger according to opts. Logs go to
+// stderr, keeping stdout for the output of commands such as reports and
+// -o json.
+func setupLogging(opts LogOptions) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(opts.Level)); err != nil {
+		return fmt.Errorf("invalid log level %q", opts.Level)
+	}
+
+	out := os.Stderr
+	var handler slog.Handler
+	switch opts.Format {
+	case "json":
+		handler = slog.NewJSONHandler(out, &slog.HandlerOptions{Level: level})
+	case "text", "":
+		color := !opts.Plain && os.Getenv("NO_COLOR") == "" && isTerminal(out)
+		handler = newTextHandler(out, level, opts.Plain, color)
+	default:
+		return fmt.Errorf("invalid log format %q (use text or json)", opts.Format)
+	}
+	slog.SetDefault(slog.New(redactHandler{next: handler}))
+	return nil
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// newRunID returns a short random ID identifying one run of a project.
+func newRunID() string {
+	id := make([]byte, 4)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// projectLogger returns a logger tagging every line with the project and the
+// run, so interleaved runs can be told apart. If excerpt is set, the lines
+// are also rendered into it for notifications.
+func projectLogger(name, runID string, excerpt *logExcerpt) *slog.Logger {
+	handler := slog.Default().Handler()
+	if excerpt != nil {
+		text := newTextHandler(excerpt, slog.LevelInfo, false, false)
+		handler = teeHandler{handler, redactHandler{next: text}}
+	}
+	return slog.New(handler).With(projectKey, name, runKey, runID)
+}
+
+// logExcerpt keeps the last lines logged during a run.
+type logExcerpt struct {
+	mu    sync.Mutex
+	max   int
+	lines []string
+}
+
+func newLogExcerpt(max int) *logExcerpt {
+	return &logExcerpt{max: max}
+}
+
+func (e *logExcerpt) Write(p []byte) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, line := range strings.Split(string(p), "\n") {
+		if line != "" {
+			e.lines = append(e.lines, line)
+		}
+	}
+	if len(e.lines) > e.max {
+		e.lines = e.lines[len(e.lines)-e.max:]
+	}
+	return len(p), nil
+}
+
+func (e *logExcerpt) String() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return strings.Join(e.lines, "\n")
+}
+
+// teeHandler passes records on to several handlers.
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	t2 := make(teeHandler, len(t))
+	for i, h := range t {
+		t2[i] = h.WithAttrs(attrs)
+	}
+	return t2
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	t2 := make(teeHandler, len(t))
+	for i, h := range t {
+		t2[i] = h.WithGroup(name)
+	}
+	return t2
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var err error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			if e := h.Handle(ctx, r.Clone()); e != nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
+
+func logStep(log *slog.Logger, msg string, args ...any) {
+	log.Info(msg, append([]any{eventKey, eventStep}, args...)...)
+}
+
+func logSuccess(log *slog.Logger, msg string, args ...any) {
+	log.Info(msg, append([]any{eventKey, eventSuccess}, args...)...)
+}
+
+func logUnchanged(log *slog.Logger, msg string, args ...any) {
+	log.Info(msg, append([]any{eventKey, eventUnchanged}, args...)...)
+}
+
+func logSkipped(log *slog.Logger, msg string, args ...any) {
+	log.Info(msg, append([]any{eventKey, eventSkipped}, args...)...)
+}
+
+func logSection(log *slog.Logger, msg string, args ...any) {
+	log.Info(msg, append([]any{eventKey, eventSection}, args...)...)
+}
+
+// logOutput logs captured command output line by line.
+func logOutput(log *slog.Logger, source string, output []byte) {
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line != "" {
+			log.Info(line, eventKey, eventOutput, "source", source)
+		}
+	}
+}
+
+// logWriter returns a writer that logs every line written to it as command
+// output, for use as the Stdout and Stderr of external commands.
+func logWriter(log *slog.Logger, source string) io.Writer {
+	return &lineLogger{log: log, source: source}
+}
+
+type lineLogger struct {
+	mu     sync.Mutex
+	log    *slog.Logger
+	source string
+	buf    bytes.Buffer
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.buf.Write(p)
+	for {
+		line, err := l.buf.ReadString('\n')
+		if err != nil {
+			// Keep the incomplete line for the next write
+			l.buf.Reset()
+			l.buf.WriteString(line)
+			break
+		}
+		logOutput(l.log, l.source, []byte(line))
+	}
+	return len(p), nil
+}
+
+// textHandler renders records the way updatectrl always printed them:
+// a symbol, the message, and the values of its attributes.
+type textHandler struct {
+	mu    *sync.Mutex
+	out   io.Writer
+	level slog.Level
+	plain bool
+	color bool
+	attrs []slog.Attr
+}
+
+func newTextHandler(out io.Writer, level slog.Level, plain, color bool) *textHandler {
+	return &textHandler{mu: &sync.Mutex{}, out: out, level: level, plain: plain, color: color}
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &h2
+}
+
+func (h *textHandler) WithGroup(name string) slog.Handler {
+	// Groups are not used by updatectrl; flatten them
+	return h
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	event := ""
+	var context, values []slog.Attr
+	for _, a := range h.attrs {
+		if a.Key == projectKey || a.Key == runKey {
+			context = append(context, a)
+		}
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		switch a.Key {
+		case eventKey:
+			event = a.Value.String()
+		case "source":
+			if event != eventOutput {
+				values = append(values, a)
+			}
+		default:
+			values = append(values, a)
+		}
+		return true
+	})
+
+	var b strings.Builder
+	if event == eventSection {
+		b.WriteString("\n")
+	}
+
+	if h.plain {
+		b.WriteString(r.Level.String())
+		b.WriteString(" ")
+		if len(context) > 0 {
+			ids := make([]string, len(context))
+			for i, a := range context {
+				ids[i] = a.Value.String()
+			}
+			b.WriteString("[" + strings.Join(ids, " ") + "] ")
+		}
+	} else if event != eventOutput {
+		symbol, color := h.symbol(r.Level, event)
+		if symbol != "" {
+			b.WriteString(h.paint(color, symbol) + " ")
+		}
+	}
+
+	b.WriteString(r.Message)
+	switch {
+	case len(values) == 1:
+		b.WriteString(": " + values[0].Value.String())
+	case len(values) > 1:
+		parts := make([]string, len(values))
+		for i, a := range values {
+			parts[i] = a.Key + "=" + a.Value.String()
+		}
+		b.WriteString(" (" + strings.Join(parts, ", ") + ")")
+	}
+	b.WriteString("\n")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.out, b.String())
+	return err
+}
+
+func (h *textHandler) symbol(level slog.Level, event string) (string, string) {
+	switch {
+	case level >= slog.LevelError:
+		return "✘", "31"
+	case level >= slog.LevelWarn:
+		return "⚠", "33"
+	}
+	switch event {
+	case eventStep, eventSection:
+		return "→", ""
+	case eventSuccess:
+		return "✓", "32"
+	case eventUnchanged:
+		return "●", "36"
+	case eventSkipped:
+		return "⊘", "90"
+	}
+	return "", ""
+}
+
+func (h *textHandler) paint(color, s string) string {
+	if !h.color || color == "" {
+		return s
+	}
+	return "\033[" + color + "m" + s + "\033[0m"
+}
@@ -7,11 +7,20 @@ import (
 )
 
 func main() {
+	var logOpts LogOptions
 	rootCmd := &cobra.Command{
 		Use:     "updatectrl",
 		Version: version,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return setupLogging(logOpts)
+		},
 	}
-	rootCmd.AddCommand(initCmd, watchCmd, buildCmd, listCmd, logsCmd)
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "Config file (default $UPDATECTRL_CONFIG, ~/.config/updatectrl/updatectrl.yaml or /etc/updatectrl/updatectrl.yaml)")
+	rootCmd.PersistentFlags().StringVar(&ageKeyFlag, "age-key", "", "age identity file to decrypt the config with (default $UPDATECTRL_AGE_KEY_FILE or age.key next to the config)")
+	rootCmd.PersistentFlags().StringVar(&logOpts.Format, "log-format", "text", "Log format: text or json")
+	rootCmd.PersistentFlags().StringVar(&logOpts.Level, "log-level", "info", "Log level: debug, info, warn or error")
+	rootCmd.PersistentFlags().BoolVar(&logOpts.Plain, "plain", false, "Plain text logs without symbols or color")
+	rootCmd.AddCommand(initCmd, watchCmd, buildCmd, listCmd, logsCmd, statusCmd, historyCmd, rollbackCmd, unpinCmd, updateCmd, runCmd, planCmd, applyCmd, triggerCmd, pauseCmd, resumeCmd, notifyCmd, validateCmd)
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
 	}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type metricFamily struct {
+	help   string
+	kind   string             // counter, gauge or summary
+	values map[string]float64 // Keyed by formatted label set
+}
+
+// metricsRegistry holds the daemon's metrics and renders them in the
+// Prometheus text exposition format.
+type metricsRegistry struct {
+	mu       sync.Mutex
+	families map[string]*metricFamily
+}
+
+var metrics = newMetricsRegistry()
+
+func newMetricsRegistry() *metricsRegistry {
+	m := &metricsRegistry{families: map[string]*metricFamily{}}
+	m.define("updatectrl_checks_total", "counter", "Number of update checks per project.")
+	m.define("updatectrl_deployments_total", "counter", "Number of successful deployments per project.")
+	m.define("updatectrl_failures_total", "counter", "Number of failed updates per project and stage.")
+	m.define("updatectrl_last_success_timestamp_seconds", "gauge", "Unix time of the last successful check or deployment.")
+	m.define("updatectrl_update_available", "gauge", "Whether a newer revision than the deployed one is known (1) or not (0).")
+	m.define("updatectrl_suspended", "gauge", "Whether the circuit breaker of the project is open.")
+	m.define("updatectrl_build_duration_seconds", "summary", "Time spent running build commands.")
+	m.define("updatectrl_pull_duration_seconds", "summary", "Time spent pulling images and git changes.")
+	m.define("updatectrl_registry_errors_total", "counter", "Number of failed registry requests per project.")
+	return m
+}
+
+func (m *metricsRegistry) define(name, kind, help string) {
+	m.families[name] = &metricFamily{help: help, kind: kind, values: map[string]float64{}}
+}
+
+func (m *metricsRegistry) add(name, labels string, v float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.families[name].values[labels] += v
+}
+
+func (m *metricsRegistry) set(name, labels string, v float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.families[name].values[labels] = v
+}
+
+// observe records a sample of a summary as its _sum and _count series.
+func (m *metricsRegistry) observe(name, labels string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f := m.families[name]
+	f.values["_sum"+labels] += d.Seconds()
+	f.values["_count"+labels]++
+}
+
+func (m *metricsRegistry) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := m.families[name]
+		fmt.Fprintf(w, "# HELP %s %s\n", name, f.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, f.kind)
+
+		keys := make([]string, 0, len(f.values))
+		for key := range f.values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			// Summary keys carry their series suffix in front of the labels
+			suffix, labels := "", key
+			if i := strings.Index(key, "{"); i > 0 {
+				suffix, labels = key[:i], key[i:]
+			}
+			fmt.Fprintf(w, "%s%s%s %g\n", name, suffix, labels, f.values[key])
+		}
+	}
+}
+
+// metricLabels formats label pairs, e.g. metricLabels("project", "web")
+// returns {project="web"}.
+func metricLabels(pairs ...string) string {
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	var parts []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, pairs[i], escaper.Replace(pairs[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// observeResult updates the metrics from the outcome of a run.
+func observeResult(res UpdateResult) {
+	project := metricLabels("project", res.Project)
+
+	metrics.add("updatectrl_checks_total", project, 1)
+	if res.PullDuration > 0 {
+		metrics.observe("updatectrl_pull_duration_seconds", project, time.Duration(res.PullDuration*float64(time.Second)))
+	}
+	if res.BuildDuration > 0 {
+		metrics.observe("updatectrl_build_duration_seconds", project, time.Duration(res.BuildDuration*float64(time.Second)))
+	}
+
+	switch res.Outcome {
+	case outcomeDeployed, outcomeRolledBack:
+		metrics.add("updatectrl_deployments_total", project, 1)
+		metrics.set("updatectrl_last_success_timestamp_seconds", project, float64(time.Now().Unix()))
+	case outcomeUnchanged:
+		metrics.set("updatectrl_last_success_timestamp_seconds", project, float64(time.Now().Unix()))
+	case outcomeFailed:
+		metrics.add("updatectrl_failures_total", metricLabels("project", res.Project, "stage", res.Stage), 1)
+	}
+	if res.Outcome != outcomeSuspended {
+		// Suspended projects aren't checked, so keep what the last check found
+		observeAvailable(res.Project, res.Outcome != outcomeDeployed && res.Outcome != outcomeRolledBack && res.NewRevision != "" && res.NewRevision != res.OldRevision)
+	}
+
+	suspended := 0.0
+	if breakerOpen(res.Project) {
+		suspended = 1
+	}
+	metrics.set("updatectrl_suspended", project, suspended)
+}
+
+// observeAvailable sets whether the last check of a project found a revision
+// that isn't deployed.
+func observeAvailable(name string, available bool) {
+	value := 0.0
+	if available {
+		value = 1
+	}
+	metrics.set("updatectrl_update_available", metricLabels("project", name), value)
+}
+
+// countRegistryError counts err as a failed registry request and returns it.
+func countRegistryError(p Project, err error) error {
+	if err != nil {
+		metrics.add("updatectrl_registry_errors_total", metricLabels("project", p.Name), 1)
+	}
+	return err
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.writeTo(w)
+}
+
+// serveMetrics exposes /metrics over HTTP for Prometheus to scrape.
+func serveMetrics(listen string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", handleMetrics)
+
+	server := &http.Server{
+		Addr:              listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       time.Minute,
+	}
+	logStep(slog.Default(), "Metrics available", "address", listen+"/metrics")
+	if err := server.ListenAndServe(); err != nil {
+		slog.Error("Metrics listener stopped", "error", err)
+	}
+}
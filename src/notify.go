@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Events that can be sent as notifications.
+const (
+	notifyUpdateAvailable = "updateAvailable"
+	notifyDeployed        = "deployed"
+	notifyFailed          = "failed"
+	notifyRolledBack      = "rolledBack"
+)
+
+var notifyEvents = []string{notifyUpdateAvailable, notifyDeployed, notifyFailed, notifyRolledBack}
+
+// Number of log lines attached to notifications.
+const notifyExcerptLines = 20
+
+// Notification is what gets sent for an event. The generic webhook
+// provider posts it as JSON as is.
+type Notification struct {
+	Event       string     `json:"event"`
+	Project     string     `json:"project"`
+	Title       string     `json:"title"`
+	OldRevision string     `json:"oldRevision,omitempty"`
+	NewRevision string     `json:"newRevision,omitempty"`
+	Stage       string     `json:"stage,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	Duration    float64    `json:"durationSeconds,omitempty"`
+	Changelog   *Changelog `json:"changelog,omitempty"`
+	Logs        string     `json:"logs,omitempty"` // Last lines logged during the run
+	Time        time.Time  `json:"time"`
+}
+
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+// Revisions we already announced as available, so the daemon doesn't
+// repeat itself every cycle.
+var (
+	announced   = map[string]string{}
+	announcedMu sync.Mutex
+)
+
+// notifyResult sends the notification for the outcome of a run, if any.
+func notifyResult(log *slog.Logger, p Project, res UpdateResult, logs string) {
+	var event, title string
+	switch res.Outcome {
+	case outcomeDeployed:
+		event, title = notifyDeployed, p.Name+" deployed"
+	case outcomeRolledBack:
+		event, title = notifyRolledBack, p.Name+" rolled back"
+	case outcomeFailed:
+		event, title = notifyFailed, p.Name+" failed"
+		if res.Stage != "" {
+			title += " at " + res.Stage
+		}
+	default:
+		return
+	}
+
+	sendNotifications(log, p, Notification{
+		Event:       event,
+		Project:     p.Name,
+		Title:       title,
+		OldRevision: res.OldRevision,
+		NewRevision: res.NewRevision,
+		Stage:       res.Stage,
+		Error:       res.Error,
+		Duration:    res.Duration,
+		Changelog:   res.Changelog,
+		Logs:        logs,
+		Time:        time.Now(),
+	})
+}
+
+// notifyAvailable announces a planned update that was not deployed.
+func notifyAvailable(p Project, action PlanAction) {
+	if action.Action != actionUpdate {
+		return
+	}
+	announceAvailable(p, action.CurrentRevision, action.TargetRevision)
+}
+
+// checkAvailable looks up the latest revision of a project that doesn't
+// follow its source, because it is paused or pinned, and announces it if
+// current is behind.
+func checkAvailable(log *slog.Logger, p Project, current string) {
+	latest, err := sourceRevision(p)
+	if err != nil || latest == "" {
+		log.Debug("Could not check for a newer revision", "error", err)
+		return
+	}
+	observeAvailable(p.Name, latest != current)
+	if latest != current {
+		logStep(log, "Newer revision available", "revision", shortRevision(latest))
+	}
+	announceAvailable(p, current, latest)
+}
+
+// announceAvailable notifies that latest is available while current is
+// deployed, once per latest revision.
+func announceAvailable(p Project, current, latest string) {
+	if latest == "" || latest == current {
+		return
+	}
+	announcedMu.Lock()
+	seen := announced[p.Name] == latest
+	announced[p.Name] = latest
+	announcedMu.Unlock()
+	if seen {
+		return
+	}
+
+	sendNotifications(slog.Default(), p, Notification{
+		Event:       notifyUpdateAvailable,
+		Project:     p.Name,
+		Title:       "Update available for " + p.Name,
+		OldRevision: current,
+		NewRevision: latest,
+		Time:        time.Now(),
+	})
+}
+
+// sendNotifications sends n to every provider of p subscribed to its event.
+// Failures are logged, they never fail the update itself.
+func sendNotifications(log *slog.Logger, p Project, n Notification) {
+	n.Title = redact(n.Title)
+	n.Error = redact(n.Error)
+	n.Logs = redact(n.Logs)
+
+	for _, cfg := range p.Notifications {
+		if !cfg.subscribed(n.Event) {
+			continue
+		}
+		if err := sendNotification(cfg, n); err != nil {
+			log.Warn("Failed to send notification", "type", cfg.Type, "error", err)
+		} else {
+			log.Debug("Sent notification", "type", cfg.Type, "notification", n.Event)
+		}
+	}
+}
+
+// subscribed reports whether cfg sends the given event.
+func (cfg NotificationConfig) subscribed(event string) bool {
+	if len(cfg.Events) > 0 {
+		return slices.Contains(cfg.Events, event)
+	}
+	if cfg.Type == "email" {
+		return event == notifyFailed
+	}
+	return true
+}
+
+func sendNotification(cfg NotificationConfig, n Notification) error {
+	var payload any
+	switch cfg.Type {
+	case "email":
+		return sendEmail(cfg, n.Title, emailBody(n))
+	case "ntfy":
+		return sendNtfy(cfg, n)
+	case "gotify":
+		return sendGotify(cfg, n)
+	case "mqtt":
+		return sendMQTT(cfg, n)
+	case "slack":
+		payload = slackPayload(n)
+	case "discord":
+		payload = discordPayload(n)
+	case "teams":
+		payload = teamsPayload(n)
+	case "webhook":
+		payload = n
+	default:
+		return fmt.Errorf("unknown notification type %q", cfg.Type)
+	}
+	return postJSON(cfg.URL, cfg.Headers, payload)
+}
+
+func postJSON(url string, headers map[string]string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
+
+// facts are the details shown below the title by the chat providers.
+func (n Notification) facts() [][2]string {
+	facts := [][2]string{{"Project", n.Project}}
+	switch {
+	case n.OldRevision != "" && n.NewRevision != "" && n.OldRevision != n.NewRevision:
+		facts = append(facts, [2]string{"Revision", shortRevision(n.OldRevision) + " → " + shortRevision(n.NewRevision)})
+	case n.NewRevision != "":
+		facts = append(facts, [2]string{"Revision", shortRevision(n.NewRevision)})
+	case n.OldRevision != "":
+		facts = append(facts, [2]string{"Revision", shortRevision(n.OldRevision)})
+	}
+	if n.Changelog != nil {
+		facts = append(facts, [2]string{"Changes", n.Changelog.summary()})
+	}
+	if n.Duration > 0 {
+		facts = append(facts, [2]string{"Duration", fmt.Sprintf("%.1fs", n.Duration)})
+	}
+	if n.Error != "" {
+		facts = append(facts, [2]string{"Error", n.Error})
+	}
+	return facts
+}
+
+// Number of commits listed in notifications.
+const notifyCommits = 10
+
+// changes lists the deployed commits, one per line.
+func (n Notification) changes() string {
+	if n.Changelog == nil {
+		return ""
+	}
+	return strings.Join(n.Changelog.lines(notifyCommits), "\n")
+}
+
+// color returns the RGB color of the event, used by Discord and Teams.
+func (n Notification) color() int {
+	switch n.Event {
+	case notifyDeployed:
+		return 0x2eb67d
+	case notifyFailed:
+		return 0xe01e5a
+	case notifyRolledBack:
+		return 0xecb22e
+	}
+	return 0x36c5f0
+}
+
+// tailString shortens s to its last max bytes, keeping whole lines.
+func tailString(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	s = s[len(s)-max:]
+	if i := strings.Index(s, "\n"); i != -1 {
+		s = s[i+1:]
+	}
+	return s
+}
+
+func slackPayload(n Notification) any {
+	var b strings.Builder
+	b.WriteString("*" + n.Title + "*\n")
+	for _, f := range n.facts() {
+		b.WriteString("*" + f[0] + ":* " + f[1] + "\n")
+	}
+	if changes := n.changes(); changes != "" {
+		b.WriteString("```\n" + changes + "\n```\n")
+	}
+	if n.Logs != "" {
+		b.WriteString("```\n" + tailString(n.Logs, 3000) + "\n```")
+	}
+	return map[string]any{"text": b.String()}
+}
+
+func discordPayload(n Notification) any {
+	var b strings.Builder
+	for _, f := range n.facts() {
+		b.WriteString("**" + f[0] + ":** " + f[1] + "\n")
+	}
+	if changes := n.changes(); changes != "" {
+		b.WriteString("```\n" + changes + "\n```\n")
+	}
+	if n.Logs != "" {
+		// Embed descriptions are limited to 4096 characters
+		b.WriteString("```\n" + tailString(n.Logs, 3000) + "\n```")
+	}
+	return map[string]any{
+		"embeds": []map[string]any{{
+			"title":       n.Title,
+			"description": b.String(),
+			"color":       n.color(),
+			"timestamp":   n.Time.Format(time.RFC3339),
+		}},
+	}
+}
+
+func teamsPayload(n Notification) any {
+	style := map[string]string{
+		notifyDeployed:   "good",
+		notifyFailed:     "attention",
+		notifyRolledBack: "warning",
+	}[n.Event]
+	if style == "" {
+		style = "accent"
+	}
+
+	var facts []map[string]string
+	for _, f := range n.facts() {
+		facts = append(facts, map[string]string{"title": f[0], "value": f[1]})
+	}
+	body := []map[string]any{
+		{"type": "TextBlock", "text": n.Title, "weight": "Bolder", "size": "Medium", "color": style, "wrap": true},
+		{"type": "FactSet", "facts": facts},
+	}
+	if changes := n.changes(); changes != "" {
+		body = append(body, map[string]any{"type": "TextBlock", "text": changes, "wrap": true})
+	}
+	if n.Logs != "" {
+		body = append(body, map[string]any{"type": "TextBlock", "text": tailString(n.Logs, 3000), "fontType": "Monospace", "wrap": true})
+	}
+
+	return map[string]any{
+		"type": "message",
+		"attachments": []map[string]any{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]any{
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"body":    body,
+			},
+		}},
+	}
+}
+
+var notifyCmd = &cobra.Command{
+	Use:   "notify <project>",
+	Short: "Send a test notification for a project",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		event, _ := cmd.Flags().GetString("event")
+		digest, _ := cmd.Flags().GetBool("digest")
+		if !slices.Contains(notifyEvents, event) {
+			fmt.Printf("Error: unknown event %q (use %s)\n", event, strings.Join(notifyEvents, ", "))
+			os.Exit(1)
+		}
+
+		config := loadConfig()
+		p, ok := findProject(config, args[0])
+		if !ok {
+			fmt.Printf("Project %s not found in configuration\n", args[0])
+			os.Exit(1)
+		}
+
+		if digest {
+			digests := collectDigests([]Project{p})
+			if len(digests) == 0 {
+				fmt.Println("No email digest is configured for", p.Name)
+				os.Exit(1)
+			}
+			for _, d := range digests {
+				if err := sendDigest(d.config, d.projects, time.Now().Add(-24*time.Hour)); err != nil {
+					fmt.Printf("Failed to send digest to %s: %s\n", strings.Join(d.config.To, ", "), redact(err.Error()))
+					os.Exit(1)
+				}
+				fmt.Println("✓ Sent digest to", strings.Join(d.config.To, ", "))
+			}
+			return
+		}
+
+		sent, failed := 0, false
+		for _, cfg := range p.Notifications {
+			if !cfg.subscribed(event) {
+				continue
+			}
+			n := Notification{
+				Event:       event,
+				Project:     p.Name,
+				Title:       "Test notification for " + p.Name,
+				OldRevision: "0123456789abcdef0123456789abcdef01234567",
+				NewRevision: "89abcdef0123456789abcdef0123456789abcdef",
+				Logs:        "→ This is a test notification sent by `updatectrl notify`",
+				Time:        time.Now(),
+			}
+			if err := sendNotification(cfg, n); err != nil {
+				fmt.Printf("✘ %s: %s\n", cfg.Type, redact(err.Error()))
+				failed = true
+				continue
+			}
+			fmt.Printf("✓ %s\n", cfg.Type)
+			sent++
+		}
+		if sent == 0 && !failed {
+			fmt.Printf("No notifications are configured for %s events of %s\n", event, p.Name)
+		}
+		if sent == 0 || failed {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	notifyCmd.Flags().String("event", notifyDeployed, "Event to send: "+strings.Join(notifyEvents, ", "))
+	notifyCmd.Flags().Bool("digest", false, "Send the daily email digest of the project now")
+}
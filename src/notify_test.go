@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testNotification = Notification{
+	Event:       notifyFailed,
+	Project:     "web",
+	Title:       "web failed at build",
+	OldRevision: "0123456789abcdef0123456789abcdef01234567",
+	NewRevision: "89abcdef0123456789abcdef0123456789abcdef",
+	Stage:       stageBuild,
+	Error:       "exit status 1",
+	Duration:    4.2,
+	Changelog: &Changelog{
+		Count:   2,
+		Commits: []Commit{{SHA: "89abcde", Subject: "Fix login", Author: "Ada"}},
+	},
+	Logs: "→ Running build command\nnpm ERR! missing script: build",
+	Time: time.Date(2025, 1, 15, 10, 30, 0, 0, time.UTC),
+}
+
+func TestPayloads(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload func(Notification) any
+		want    []string
+	}{
+		{
+			name:    "slack",
+			payload: slackPayload,
+			want: []string{
+				`"text":"*web failed at build*\n`,
+				`*Revision:* 0123456789ab → 89abcdef0123\n`,
+				`*Changes:* 2 commits\n`,
+				`*Duration:* 4.2s\n`,
+				`*Error:* exit status 1\n`,
+				"89abcde Fix login (Ada)\\n… and 1 more",
+				"npm ERR! missing script: build",
+			},
+		},
+		{
+			name:    "discord",
+			payload: discordPayload,
+			want: []string{
+				`"title":"web failed at build"`,
+				`**Project:** web\n`,
+				`**Error:** exit status 1\n`,
+				`"color":14687834`,
+				`"timestamp":"2025-01-15T10:30:00Z"`,
+			},
+		},
+		{
+			name:    "teams",
+			payload: teamsPayload,
+			want: []string{
+				`"contentType":"application/vnd.microsoft.card.adaptive"`,
+				`"color":"attention"`,
+				`{"title":"Revision","value":"0123456789ab → 89abcdef0123"}`,
+				`"fontType":"Monospace"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.payload(testNotification))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(data), want) {
+					t.Errorf("payload does not contain %s:\n%s", want, data)
+				}
+			}
+		})
+	}
+}
+
+func TestPayloadsWithoutDetails(t *testing.T) {
+	n := Notification{Event: notifyUpdateAvailable, Project: "web", Title: "Update available for web", NewRevision: "sha256:89abcdef0123456789"}
+	for name, payload := range map[string]func(Notification) any{"slack": slackPayload, "discord": discordPayload, "teams": teamsPayload} {
+		data, err := json.Marshal(payload(n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "```") || strings.Contains(string(data), "Monospace") {
+			t.Errorf("%s: payload without changelog or logs has a code block:\n%s", name, data)
+		}
+		if !strings.Contains(string(data), "89abcdef0123") {
+			t.Errorf("%s: payload does not contain the revision:\n%s", name, data)
+		}
+	}
+}
+
+func TestTailString(t *testing.T) {
+	tests := []struct {
+		s    string
+		max  int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"line one\nline two\nline three", 14, "line three"},
+		{"line one\nline two", 9, "line two"},
+	}
+	for _, tt := range tests {
+		if got := tailString(tt.s, tt.max); got != tt.want {
+			t.Errorf("tailString(%q, %d) = %q, want %q", tt.s, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestSendNotification(t *testing.T) {
+	var got struct {
+		method, contentType, auth string
+		body                      []byte
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.method = r.Method
+		got.contentType = r.Header.Get("Content-Type")
+		got.auth = r.Header.Get("Authorization")
+		got.body, _ = io.ReadAll(r.Body)
+		if r.URL.Path == "/fail" {
+			http.Error(w, "nope", http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	cfg := NotificationConfig{Type: "webhook", URL: server.URL + "/hook", Headers: map[string]string{"Authorization": "Bearer token"}}
+	if err := sendNotification(cfg, testNotification); err != nil {
+		t.Fatal(err)
+	}
+	if got.method != "POST" || got.contentType != "application/json" || got.auth != "Bearer token" {
+		t.Errorf("got %s with Content-Type %q and Authorization %q", got.method, got.contentType, got.auth)
+	}
+	var sent Notification
+	if err := json.Unmarshal(got.body, &sent); err != nil {
+		t.Fatal(err)
+	}
+	if sent.Project != "web" || sent.Event != notifyFailed || sent.Changelog == nil || sent.Changelog.Count != 2 {
+		t.Errorf("webhook received %+v", sent)
+	}
+
+	cfg = NotificationConfig{Type: "slack", URL: server.URL + "/hook"}
+	if err := sendNotification(cfg, testNotification); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(got.body), `{"text":"*web failed at build*`) {
+		t.Errorf("slack received %s", got.body)
+	}
+
+	cfg = NotificationConfig{Type: "discord", URL: server.URL + "/fail"}
+	if err := sendNotification(cfg, testNotification); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("expected an error for a 403 response, got %v", err)
+	}
+
+	cfg = NotificationConfig{Type: "pager", URL: server.URL}
+	if err := sendNotification(cfg, testNotification); err == nil {
+		t.Error("expected an error for an unknown type")
+	}
+}
+
+func TestSubscribed(t *testing.T) {
+	tests := []struct {
+		cfg   NotificationConfig
+		event string
+		want  bool
+	}{
+		{NotificationConfig{Type: "slack"}, notifyDeployed, true},
+		{NotificationConfig{Type: "slack", Events: []string{notifyFailed}}, notifyDeployed, false},
+		{NotificationConfig{Type: "email"}, notifyDeployed, false},
+		{NotificationConfig{Type: "email"}, notifyFailed, true},
+		{NotificationConfig{Type: "email", Events: []string{notifyDeployed}}, notifyDeployed, true},
+	}
+	for _, tt := range tests {
+		if got := tt.cfg.subscribed(tt.event); got != tt.want {
+			t.Errorf("%+v subscribed(%s) = %v, want %v", tt.cfg, tt.event, got, tt.want)
+		}
+	}
+}
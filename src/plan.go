@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Actions a plan can schedule for a project.
+const (
+	actionNone     = "none"
+	actionUpdate   = "update"
+	actionStart    = "start"
+	actionRollback = "rollback"
+	actionError    = "error"
+)
+
+// PlanAction is what updateProject would do for a project, determined
+// using read-only checks only.
+type PlanAction struct {
+	Project         string   `json:"project"`
+	Type            string   `json:"type"`
+	Action          string   `json:"action"`
+	CurrentRevision string   `json:"currentRevision,omitempty"`
+	TargetRevision  string   `json:"targetRevision,omitempty"` // Empty when the upstream could not be checked
+	Reason          string   `json:"reason"`
+	Steps           []string `json:"steps,omitempty"`
+}
+
+// Plan is the output of `plan`, which `apply` can execute later.
+type Plan struct {
+	CreatedAt time.Time    `json:"createdAt"`
+	Actions   []PlanAction `json:"actions"`
+}
+
+func planProject(p Project) PlanAction {
+	action := PlanAction{Project: p.Name, Type: p.Type, Action: actionNone}
+
+	if s, err := loadState(); err == nil {
+		if ps, ok := s.Projects[p.Name]; ok {
+			p.pin = ps.Pinned
+		}
+	}
+
+	if p.Type == "image" {
+		planImageProject(p, &action)
+	} else {
+		planGitProject(p, &action)
+	}
+	return action
+}
+
+func planImageProject(p Project, action *PlanAction) {
+	if p.Image == "" {
+		action.Action = actionError
+		action.Reason = "no image specified"
+		return
+	}
+
+	containerName := p.ContainerName
+	if containerName == "" {
+		containerName = p.Name
+	}
+	running, containerImage := containerState(containerName)
+
+	if digest, err := getImageDigest(p.Image); err == nil {
+		action.CurrentRevision = digestHash(digest)
+	}
+
+	if p.pin != "" {
+		ref := imageRepository(p.Image) + "@" + p.pin
+		action.TargetRevision = p.pin
+		if running && containerImage == ref {
+			action.Reason = "pinned image already running"
+			return
+		}
+		action.Action = actionRollback
+		action.Reason = "pinned by rollback"
+		action.Steps = []string{"docker pull " + ref, "recreate container " + containerName}
+		return
+	}
+
+	remoteDigest, err := getRemoteImageDigest(p.Image)
+	if err != nil {
+		action.Reason = fmt.Sprintf("could not check remote digest: %v", err)
+	}
+	action.TargetRevision = remoteDigest
+
+	switch {
+	case action.CurrentRevision == "":
+		action.Action = actionUpdate
+		action.Reason = "image not present locally"
+	case remoteDigest == "":
+		action.Action = actionUpdate
+	case action.CurrentRevision != remoteDigest:
+		action.Action = actionUpdate
+		action.Reason = "new image digest available"
+	case !running:
+		action.Action = actionStart
+		action.Reason = "container not running"
+		action.Steps = []string{"recreate container " + containerName}
+		return
+	case !runsImage(containerName, p.Image):
+		action.Action = actionStart
+		action.Reason = "container runs another image"
+		action.Steps = []string{"recreate container " + containerName}
+		return
+	default:
+		action.Reason = "image up to date and container running"
+		return
+	}
+	action.Steps = []string{"docker pull " + p.Image, "recreate container " + containerName}
+}
+
+func planGitProject(p Project, action *PlanAction) {
+	if _, err := os.Stat(p.Path); os.IsNotExist(err) {
+		action.Action = actionError
+		action.Reason = "path not found: " + p.Path
+		return
+	}
+
+	action.CurrentRevision, _ = gitRevision(p.Path)
+
+	var steps []string
+	if p.pin != "" {
+		action.TargetRevision = p.pin
+		if action.CurrentRevision == p.pin {
+			action.Reason = "pinned commit already checked out"
+			return
+		}
+		action.Action = actionRollback
+		action.Reason = "pinned by rollback"
+		steps = append(steps, "git checkout --detach "+shortRevision(p.pin))
+	} else {
+		remote, err := gitRemoteRevision(p.Path, "")
+		if err != nil {
+			action.Action = actionUpdate
+			action.Reason = fmt.Sprintf("could not check remote: %v", err)
+		} else if remote == action.CurrentRevision {
+			action.TargetRevision = remote
+			action.Reason = "no new commits"
+			return
+		} else {
+			action.Action = actionUpdate
+			action.TargetRevision = remote
+			action.Reason = "new commits available"
+		}
+		steps = append(steps, "git pull")
+	}
+
+	if p.BuildCommand != "" {
+		steps = append(steps, "run "+p.BuildCommand)
+	}
+	if p.Type == "pm2" {
+		steps = append(steps, "pm2 restart "+p.Name)
+	}
+	action.Steps = steps
+}
+
+func printPlan(actions []PlanAction) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROJECT\tACTION\tCURRENT\tTARGET\tREASON")
+	for _, a := range actions {
+		current, target := shortRevision(a.CurrentRevision), shortRevision(a.TargetRevision)
+		if current == "" {
+			current = "-"
+		}
+		if target == "" {
+			target = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", a.Project, a.Action, current, target, a.Reason)
+	}
+	w.Flush()
+
+	for _, a := range actions {
+		if len(a.Steps) == 0 {
+			continue
+		}
+		fmt.Printf("\n%s:\n", a.Project)
+		for _, step := range a.Steps {
+			fmt.Printf("  - %s\n", step)
+		}
+	}
+}
+
+// logPlan logs the planned actions, for the daemon's dry-run mode.
+func logPlan(actions []PlanAction) {
+	for _, a := range actions {
+		args := []any{projectKey, a.Project, "action", a.Action, "reason", a.Reason}
+		if len(a.Steps) > 0 {
+			args = append(args, "steps", strings.Join(a.Steps, "; "))
+		}
+		if a.Action == actionNone {
+			logUnchanged(slog.Default(), "Planned", args...)
+		} else {
+			logStep(slog.Default(), "Planned", args...)
+		}
+	}
+}
+
+// selectProjects returns the named projects, or all of them when names is empty.
+// errUnknownProject is returned for project names that aren't configured.
+var errUnknownProject = errors.New("not found in configuration")
+
+func selectProjects(config Config, names []string) ([]Project, error) {
+	if len(names) == 0 {
+		return config.Projects, nil
+	}
+	var projects []Project
+	for _, name := range names {
+		p, ok := findProject(config, name)
+		if !ok {
+			return nil, fmt.Errorf("project %s %w", name, errUnknownProject)
+		}
+		projects = append(projects, p)
+	}
+	return projects, nil
+}
+
+var planCmd = &cobra.Command{
+	Use:   "plan [project...]",
+	Short: "Show what an update would do without changing anything",
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		out, _ := cmd.Flags().GetString("out")
+
+		projects, err := selectProjects(loadConfig(), args)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+
+		plan := Plan{CreatedAt: time.Now(), Actions: []PlanAction{}}
+		for _, p := range projects {
+			plan.Actions = append(plan.Actions, planProject(p))
+		}
+
+		data, _ := json.MarshalIndent(plan, "", "  ")
+		if out != "" {
+			if err := os.WriteFile(out, append(data, '\n'), 0644); err != nil {
+				fmt.Println("Failed to write plan:", err)
+				os.Exit(1)
+			}
+		}
+
+		if output == "json" {
+			fmt.Println(string(data))
+		} else {
+			printPlan(plan.Actions)
+		}
+		if out != "" && output != "json" {
+			fmt.Printf("\nSaved plan to %s. Run `updatectrl apply %s` to execute it.\n", out, out)
+		}
+	},
+}
+
+var applyCmd = &cobra.Command{
+	Use:   "apply <plan-file>",
+	Short: "Execute a plan saved with plan --out",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			fmt.Println("Failed to read plan:", err)
+			os.Exit(1)
+		}
+		var plan Plan
+		if err := json.Unmarshal(data, &plan); err != nil {
+			fmt.Println("Failed to parse plan:", err)
+			os.Exit(1)
+		}
+
+		config := loadConfig()
+		viaDaemon := daemonRunning()
+		failed := 0
+		for _, a := range plan.Actions {
+			if a.Action == actionNone || a.Action == actionError {
+				continue
+			}
+			p, ok := findProject(config, a.Project)
+			if !ok {
+				fmt.Printf("✘ Project %s not found in configuration\n", a.Project)
+				failed++
+				continue
+			}
+
+			// Refuse to act on a project that changed since the plan was made
+			current, _ := localRevision(p)
+			if current != a.CurrentRevision {
+				fmt.Printf("✘ %s changed since the plan was created (%s, expected %s), skipping\n",
+					p.Name, shortRevision(current), shortRevision(a.CurrentRevision))
+				failed++
+				continue
+			}
+
+			fmt.Printf("\n→ Applying %s to %s\n", a.Action, p.Name)
+			if a.Action != actionRollback {
+				p.target = a.TargetRevision
+			}
+			if viaDaemon {
+				n, err := updateThroughDaemon(updateRequest{Projects: []string{p.Name}, Targets: map[string]string{p.Name: p.target}})
+				if err != nil {
+					fmt.Printf("Update failed for %s: %v\n", p.Name, err)
+					n = 1
+				}
+				failed += n
+				continue
+			}
+			resetBreaker(p.Name)
+			if _, err := runProject(p); err != nil {
+				fmt.Printf("Update failed for %s: %v\n", p.Name, err)
+				failed++
+			}
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	planCmd.Flags().StringP("output", "o", "table", "Output format (table or json)")
+	planCmd.Flags().String("out", "", "Save the plan to this file for apply")
+}
+
+// containerState reports whether a container is running and which image it was created from.
+func containerState(name string) (bool, string) {
+	output, err := exec.Command("docker", "inspect", "-f", "{{.State.Running}} {{.Config.Image}}", name).Output()
+	if err != nil {
+		return false, ""
+	}
+	running, image, _ := strings.Cut(strings.TrimSpace(string(output)), " ")
+	return running == "true", image
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMQTTPacket(t *testing.T) {
+	tests := []struct {
+		name       string
+		packetType byte
+		bodyLen    int
+		header     []byte
+	}{
+		{"empty", mqttDisconnect, 0, []byte{0xe0, 0x00}},
+		{"one byte length", mqttPublish, 127, []byte{0x30, 0x7f}},
+		{"two byte length", mqttPublish, 128, []byte{0x30, 0x80, 0x01}},
+		{"largest two byte length", mqttPublish, 16383, []byte{0x30, 0xff, 0x7f}},
+		{"three byte length", mqttConnect, 16384, []byte{0x10, 0x80, 0x80, 0x01}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := bytes.Repeat([]byte{'x'}, tt.bodyLen)
+			var buf bytes.Buffer
+			if err := writeMQTTPacket(&buf, tt.packetType, body); err != nil {
+				t.Fatal(err)
+			}
+			got := buf.Bytes()
+			if !bytes.Equal(got[:min(len(got), len(tt.header))], tt.header) {
+				t.Errorf("header = % x, want % x", got[:min(len(got), len(tt.header))], tt.header)
+			}
+			if !bytes.Equal(got[len(tt.header):], body) {
+				t.Errorf("packet has %d body bytes, want %d", len(got)-len(tt.header), len(body))
+			}
+		})
+	}
+}
+
+func TestAppendMQTTString(t *testing.T) {
+	got := appendMQTTString([]byte{0x01}, "MQTT")
+	want := []byte{0x01, 0x00, 0x04, 'M', 'Q', 'T', 'T'}
+	if !bytes.Equal(got, want) {
+		t.Errorf("appendMQTTString = % x, want % x", got, want)
+	}
+}
+
+// readMQTTPacket reads one packet written by writeMQTTPacket.
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	packetType, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	return packetType, body, err
+}
+
+func TestPublishMQTT(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    byte
+		wantErr string
+	}{
+		{"accepted", 0, ""},
+		{"refused", 4, "broker refused connection (bad username or password)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+
+			var packets [][]byte
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				r := bufio.NewReader(conn)
+				for {
+					packetType, body, err := readMQTTPacket(r)
+					if err != nil {
+						return
+					}
+					packets = append(packets, append([]byte{packetType}, body...))
+					if packetType == mqttConnect {
+						conn.Write([]byte{mqttConnack, 2, 0, tt.code})
+					}
+				}
+			}()
+
+			err = publishMQTT("mqtt://user:pass@"+listener.Addr().String(), "updatectrl/web/failed", []byte(`{"project":"web"}`))
+			<-done
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("publishMQTT error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(packets) != 3 || packets[0][0] != mqttConnect || packets[1][0] != mqttPublish || packets[2][0] != mqttDisconnect {
+				t.Fatalf("broker received % x", packets)
+			}
+			connect := packets[0][1:]
+			if !bytes.HasPrefix(connect, []byte{0, 4, 'M', 'Q', 'T', 'T', 4, 0xc2, 0, 60}) {
+				t.Errorf("CONNECT header = % x", connect[:10])
+			}
+			if !bytes.HasSuffix(connect, []byte{0, 4, 'u', 's', 'e', 'r', 0, 4, 'p', 'a', 's', 's'}) {
+				t.Errorf("CONNECT does not end with the credentials: % x", connect)
+			}
+			publish := packets[1][1:]
+			want := append(appendMQTTString(nil, "updatectrl/web/failed"), `{"project":"web"}`...)
+			if !bytes.Equal(publish, want) {
+				t.Errorf("PUBLISH body = %q, want %q", publish, want)
+			}
+		})
+	}
+}
+
+func TestPublishMQTTScheme(t *testing.T) {
+	err := publishMQTT("http://localhost", "topic", nil)
+	if err == nil || !strings.Contains(err.Error(), "unsupported MQTT scheme") {
+		t.Errorf("expected an unsupported scheme error, got %v", err)
+	}
+}
+
+func TestPriorityAndTopic(t *testing.T) {
+	n := Notification{Event: notifyFailed, Project: "web"}
+	tests := []struct {
+		cfg          NotificationConfig
+		wantPriority int
+		wantTopic    string
+	}{
+		{NotificationConfig{Type: "ntfy", Topic: "deploys"}, 5, "deploys"},
+		{NotificationConfig{Type: "gotify"}, 8, "updatectrl/web/failed"},
+		{NotificationConfig{Type: "mqtt", Topic: "home/{project}/{event}", Priority: map[string]int{notifyFailed: 1}}, 1, "home/web/failed"},
+	}
+	for _, tt := range tests {
+		if got := tt.cfg.priority(n.Event); got != tt.wantPriority {
+			t.Errorf("%s priority = %d, want %d", tt.cfg.Type, got, tt.wantPriority)
+		}
+		if got := tt.cfg.topic(n, "updatectrl/{project}/{event}"); got != tt.wantTopic {
+			t.Errorf("%s topic = %q, want %q", tt.cfg.Type, got, tt.wantTopic)
+		}
+	}
+}
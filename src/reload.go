@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"maps"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"time"
+)
+
+// watchConfig reloads the configuration when the file or one of its includes
+// changes, or the daemon receives SIGHUP. The included files are watched as
+// configured at startup.
+func (d *daemon) watchConfig() {
+	changed := make(chan struct{}, 1)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	d.mu.Lock()
+	patterns := watchedPatterns(configPath(), d.config)
+	d.mu.Unlock()
+	go func() {
+		if err := watchFiles(patterns, changed); err != nil {
+			slog.Debug("Watching the config files for changes", "method", "polling", "reason", err)
+			pollFiles(patterns, changed)
+		}
+	}()
+
+	for {
+		select {
+		case <-changed:
+			// Editors often write a file in several steps; wait for them to finish
+			time.Sleep(500 * time.Millisecond)
+			select {
+			case <-changed:
+			default:
+			}
+			logSection(slog.Default(), "Config file changed, reloading")
+		case <-signals:
+			logSection(slog.Default(), "Received SIGHUP, reloading config")
+		}
+		d.reload(true)
+	}
+}
+
+// watchedPatterns returns the config file at path and the files it includes,
+// as absolute paths so they match the paths of file events whichever way
+// path was written, e.g. --config ./updatectrl.yaml.
+func watchedPatterns(path string, c Config) []string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	patterns := []string{path}
+	if c.Source == nil {
+		// A config from git is pulled each cycle rather than watched
+		patterns = append(patterns, includePatterns(path, c.Include)...)
+	}
+	return patterns
+}
+
+// reload reads the configuration again and applies it. With checkChanged,
+// projects that were added or changed are checked right away; runs in
+// progress are not interrupted. An invalid configuration is rejected and the
+// current one kept.
+func (d *daemon) reload(checkChanged bool) {
+	config, err := readConfig()
+	if err != nil {
+		slog.Error("Invalid config, keeping the current one")
+		var configErrs ConfigErrors
+		if errors.As(err, &configErrs) {
+			for _, e := range configErrs {
+				slog.Error(e.Error())
+			}
+		} else {
+			slog.Error(err.Error())
+		}
+		return
+	}
+
+	d.mu.Lock()
+	old := d.config
+	d.config = config
+	d.mu.Unlock()
+	configureRedaction(config)
+
+	current := map[string]Project{}
+	for _, p := range old.Projects {
+		current[p.Name] = p
+	}
+	var names []string
+	for _, p := range config.Projects {
+		prev, ok := current[p.Name]
+		delete(current, p.Name)
+		switch {
+		case !ok:
+			logSuccess(slog.Default(), "Added project", projectKey, p.Name)
+		case !reflect.DeepEqual(prev, p):
+			logStep(slog.Default(), "Changed project", projectKey, p.Name)
+			resetBreaker(p.Name)
+		default:
+			continue
+		}
+		names = append(names, p.Name)
+	}
+	for name := range current {
+		logSkipped(slog.Default(), "Removed project", projectKey, name)
+		resetBreaker(name)
+	}
+
+	if config.intervalSeconds() != old.intervalSeconds() {
+		logStep(slog.Default(), "Changed interval", "seconds", config.intervalSeconds())
+		select {
+		case d.reloaded <- struct{}{}:
+		default:
+		}
+	}
+	if !reflect.DeepEqual(config.Webhook, old.Webhook) || !reflect.DeepEqual(config.Metrics, old.Metrics) {
+		slog.Warn("Restart the daemon to apply changes to the webhook and metrics listeners")
+	}
+
+	if checkChanged && len(names) > 0 {
+		if err := d.trigger(names); err != nil {
+			slog.Warn("Could not schedule changed projects", "error", err)
+		}
+	}
+	if config.revision != "" {
+		logSuccess(slog.Default(), "Reloaded config", "projects", len(config.Projects), "revision", shortRevision(config.revision))
+	} else {
+		logSuccess(slog.Default(), "Reloaded config", "projects", len(config.Projects))
+	}
+}
+
+// pollFiles signals changed whenever a file matching one of the glob
+// patterns is added or removed, or its modification time or size changes.
+func pollFiles(patterns []string, changed chan<- struct{}) {
+	type fileInfo struct {
+		modTime time.Time
+		size    int64
+	}
+	stat := func() map[string]fileInfo {
+		files := map[string]fileInfo{}
+		for _, pattern := range patterns {
+			matches, _ := filepath.Glob(pattern)
+			for _, path := range matches {
+				if info, err := os.Stat(path); err == nil {
+					files[path] = fileInfo{info.ModTime(), info.Size()}
+				}
+			}
+		}
+		return files
+	}
+
+	files := stat()
+	for range time.Tick(2 * time.Second) {
+		current := stat()
+		if maps.Equal(files, current) {
+			continue
+		}
+		files = current
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// watchFiles signals changed whenever a file matching one of the glob
+// patterns is written, replaced or removed, using inotify. The directories
+// are watched, as editors and config management tools usually replace files
+// rather than write to them. For directories that don't exist yet, such as
+// conf.d, the closest existing parent is watched until they are created.
+func watchFiles(patterns []string, changed chan<- struct{}) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	const mask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE | syscall.IN_DELETE
+	dirs := map[int32]string{}
+	missing := map[string]bool{}
+
+	// watch adds a watch on dir, or on its closest existing parent if it
+	// doesn't exist, and reports whether dir itself is watched
+	watch := func(dir string) (bool, error) {
+		for parent := dir; ; parent = filepath.Dir(parent) {
+			wd, err := syscall.InotifyAddWatch(fd, parent, mask)
+			if err == syscall.ENOENT && parent != filepath.Dir(parent) {
+				continue
+			} else if err != nil {
+				return false, os.NewSyscallError("inotify_add_watch", err)
+			}
+			dirs[int32(wd)] = parent
+			return parent == dir, nil
+		}
+	}
+
+	for i, pattern := range patterns {
+		dir := filepath.Dir(pattern)
+		if i == 0 {
+			// The config file's own directory must exist
+			wd, err := syscall.InotifyAddWatch(fd, dir, mask)
+			if err != nil {
+				return os.NewSyscallError("inotify_add_watch", err)
+			}
+			dirs[int32(wd)] = dir
+			continue
+		}
+		ok, err := watch(dir)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			missing[dir] = true
+		}
+	}
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := syscall.Read(fd, buf)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			return os.NewSyscallError("read", err)
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			start := offset + syscall.SizeofInotifyEvent
+			eventName := string(bytes.TrimRight(buf[start:start+int(event.Len)], "\x00"))
+			offset = start + int(event.Len)
+
+			path := filepath.Join(dirs[event.Wd], eventName)
+			if event.Mask&syscall.IN_ISDIR != 0 && len(missing) > 0 {
+				// A directory on the way to a missing one was created; it
+				// may already hold config files, e.g. when moved in place
+				for dir := range missing {
+					ok, err := watch(dir)
+					if err != nil {
+						return err
+					}
+					if ok {
+						delete(missing, dir)
+						select {
+						case changed <- struct{}{}:
+						default:
+						}
+					}
+				}
+				continue
+			}
+			for _, pattern := range patterns {
+				if ok, _ := filepath.Match(pattern, path); ok {
+					select {
+					case changed <- struct{}{}:
+					default:
+					}
+					break
+				}
+			}
+		}
+	}
+}
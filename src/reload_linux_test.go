@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestWatchedPatterns(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	got := watchedPatterns("./updatectrl.yaml", Config{Include: []string{"projects/*.yaml"}})
+	want := []string{filepath.Join(dir, "updatectrl.yaml"), filepath.Join(dir, "conf.d", "*.yaml"), filepath.Join(dir, "conf.d", "*.yml"), filepath.Join(dir, "projects", "*.yaml")}
+	if !slices.Equal(got, want) {
+		t.Errorf("watchedPatterns = %q, want %q", got, want)
+	}
+	if got := watchedPatterns("updatectrl.yaml", Config{Source: &SourceConfig{Repo: "https://github.com/acme/deploy"}}); len(got) != 1 {
+		t.Errorf("watchedPatterns with a source = %q, want only the config file", got)
+	}
+}
+
+func TestWatchFilesRelativeConfig(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("updatectrl.yaml", []byte("interval: 300\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	changed := make(chan struct{}, 1)
+	go watchFiles(watchedPatterns("./updatectrl.yaml", Config{}), changed)
+	time.Sleep(100 * time.Millisecond)
+	if err := os.WriteFile("updatectrl.yaml", []byte("interval: 600\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(2 * time.Second):
+		t.Error("writing the config file given as ./updatectrl.yaml was not noticed")
+	}
+}
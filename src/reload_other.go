@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// watchFiles is only implemented with inotify on Linux; elsewhere the config
+// files are polled.
+func watchFiles(patterns []string, changed chan<- struct{}) error {
+	return errors.ErrUnsupported
+}
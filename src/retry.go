@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// permanentErrors are messages of docker and git that retrying won't fix.
+var permanentErrors = []string{
+	"unauthorized",
+	"authentication required",
+	"authentication failed",
+	"access denied",
+	"denied:",
+	"permission denied",
+	"manifest unknown",
+	"not found: manifest",
+	"repository does not exist",
+	"invalid reference format",
+	"no such file or directory",
+	"not a git repository",
+	"could not read username",
+	"couldn't find remote ref",
+	"not possible to fast-forward",
+}
+
+// transient reports whether err may go away when retried. A missing program
+// or path, or a failed authentication or lookup, won't.
+func transient(err error) bool {
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+		return false
+	}
+	msg := err.Error()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		msg += " " + string(exitErr.Stderr)
+	}
+	msg = strings.ToLower(msg)
+	for _, permanent := range permanentErrors {
+		if strings.Contains(msg, permanent) {
+			return false
+		}
+	}
+	return true
+}
+
+// commandError adds the last line of the output of a failed command to err,
+// so it can be told apart from other failures and is shown with it.
+func commandError(err error, output []byte) error {
+	if err == nil {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if line := strings.TrimSpace(lines[len(lines)-1]); line != "" {
+		return fmt.Errorf("%w: %s", err, line)
+	}
+	return err
+}
+
+// withRetry runs fn until it succeeds or the project's retry attempts are
+// used up, doubling the delay between attempts up to MaxDelay. Errors that
+// aren't transient are returned right away.
+func withRetry(log *slog.Logger, p Project, step string, fn func() error) error {
+	retry := RetryConfig{Attempts: 1}
+	if p.Retry != nil {
+		retry = *p.Retry
+	}
+	// Always make the first attempt, whatever the settings say
+	retry.Attempts = max(retry.Attempts, 1)
+
+	delay := time.Duration(retry.Delay) * time.Second
+	maxDelay := time.Duration(retry.MaxDelay) * time.Second
+
+	var err error
+	for attempt := 1; attempt <= retry.Attempts; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if attempt == retry.Attempts {
+			break
+		}
+		if !transient(err) {
+			log.Warn(step+" failed, not retrying", "error", err)
+			break
+		}
+		log.Warn(step+" failed, retrying", "attempt", fmt.Sprintf("%d/%d", attempt, retry.Attempts), "error", err, "delay", delay)
+		time.Sleep(delay)
+		delay *= 2
+		if maxDelay > 0 && delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+	return err
+}
+
+// runProject updates a project unless its circuit breaker is open, and
+// records the outcome so repeatedly failing projects get suspended.
+func runProject(p Project) (UpdateResult, error) {
+	res := UpdateResult{
+		Project:   p.Name,
+		Run:       newRunID(),
+		Type:      p.Type,
+		Outcome:   outcomeUnchanged,
+		StartedAt: time.Now(),
+	}
+	excerpt := newLogExcerpt(notifyExcerptLines)
+	log := projectLogger(p.Name, res.Run, excerpt)
+
+	// The circuit breaker is kept in the state, so it also holds across
+	// run --once and update invocations
+	var open bool
+	var revision string
+	if s, err := loadState(); err == nil {
+		if ps, ok := s.Projects[p.Name]; ok {
+			p.pin = ps.Pinned
+			p.branch = ps.Branch
+			open, revision = ps.Suspended, ps.SuspendedRevision
+		}
+	}
+
+	var err error
+	if open {
+		current, revErr := sourceRevision(p)
+		if revErr == nil && current != "" && revision == "" {
+			// The source couldn't be looked up when the breaker opened; wait
+			// for it to change from what it is now
+			recordSuspendedRevision(p.Name, current)
+			revision = current
+		}
+		if revErr != nil || current == "" || current == revision {
+			logSkipped(log, "Project suspended after repeated failures")
+			res.Outcome = outcomeSuspended
+			err = fmt.Errorf("circuit breaker open")
+		} else {
+			logStep(log, "Source changed, resuming suspended project")
+			resetBreaker(p.Name)
+		}
+	}
+
+	if res.Outcome != outcomeSuspended {
+		err = updateProject(log, p, &res)
+		recordOutcome(log, p, err)
+	}
+
+	if err != nil {
+		res.Error = redact(err.Error())
+		if res.Outcome != outcomeSuspended {
+			res.Outcome = outcomeFailed
+		}
+	}
+	end := time.Now()
+	res.Duration = end.Sub(res.StartedAt).Seconds()
+	res.Logs = logsPointer(res.StartedAt, end)
+	recordResult(log, res)
+	observeResult(res)
+	notifyResult(log, p, res, excerpt.String())
+	switch {
+	case p.pin != "" && res.Outcome != outcomeSuspended:
+		// Pinned projects don't follow their source, but should know what they miss
+		checkAvailable(log, p, p.pin)
+	case res.Outcome == outcomeFailed && res.NewRevision != "" && res.NewRevision != res.OldRevision:
+		announceAvailable(p, res.OldRevision, res.NewRevision)
+	}
+	return res, err
+}
+
+// timed returns fn, storing how long each call takes in d. Used with
+// withRetry, d ends up with the duration of the last attempt, without the
+// backoff before it.
+func timed(d *time.Duration, fn func() error) func() error {
+	return func() error {
+		start := time.Now()
+		defer func() { *d = time.Since(start) }()
+		return fn()
+	}
+}
+
+// recordOutcome counts consecutive failures of a project and opens its
+// circuit breaker once they reach the threshold.
+func recordOutcome(log *slog.Logger, p Project, err error) {
+	threshold := 0
+	if p.CircuitBreaker != nil {
+		threshold = p.CircuitBreaker.Threshold
+	}
+
+	// Look up the source revision the breaker waits to change before taking
+	// the state lock, as it contacts the registry or git remote
+	var revision string
+	if err != nil && threshold > 0 {
+		failures, suspended := 0, false
+		if s, err := loadState(); err == nil && s.Projects[p.Name] != nil {
+			failures, suspended = s.Projects[p.Name].Failures, s.Projects[p.Name].Suspended
+		}
+		if !suspended && failures+1 >= threshold {
+			revision, _ = sourceRevision(p)
+		}
+	}
+
+	var opened bool
+	var failures int
+	stateErr := updateState(func(s *State) {
+		ps := s.project(p.Name)
+		if err == nil {
+			ps.Failures = 0
+			return
+		}
+		ps.Failures++
+		failures = ps.Failures
+		if threshold > 0 && ps.Failures >= threshold && !ps.Suspended {
+			ps.Suspended, ps.SuspendedRevision = true, revision
+			opened = true
+		}
+	})
+	if stateErr != nil {
+		log.Error("Failed to save state", "error", stateErr)
+	}
+	if opened {
+		log.Error("Failed too many times in a row, suspending until the source changes", "failures", failures)
+	}
+}
+
+// recordSuspendedRevision sets the source revision a suspended project
+// waits to change, if it wasn't known when its circuit breaker opened.
+func recordSuspendedRevision(name, revision string) {
+	err := updateState(func(s *State) {
+		if ps, ok := s.Projects[name]; ok && ps.Suspended && ps.SuspendedRevision == "" {
+			ps.SuspendedRevision = revision
+		}
+	})
+	if err != nil {
+		slog.Error("Failed to save state", "error", err)
+	}
+}
+
+// breakerOpen reports whether a project is suspended by its circuit breaker.
+func breakerOpen(name string) bool {
+	s, err := loadState()
+	return err == nil && s.Projects[name] != nil && s.Projects[name].Suspended
+}
+
+// resetBreaker closes the circuit breaker of a project and clears its failures.
+func resetBreaker(name string) {
+	err := updateState(func(s *State) {
+		if ps, ok := s.Projects[name]; ok {
+			ps.Failures, ps.Suspended, ps.SuspendedRevision = 0, false, ""
+		}
+	})
+	if err != nil {
+		slog.Error("Failed to save state", "error", err)
+	}
+}
+
+// sourceRevision returns the latest upstream revision of a project: the
+// remote image digest for image projects, the upstream commit otherwise.
+func sourceRevision(p Project) (string, error) {
+	if p.Type == "image" {
+		return getRemoteImageDigest(p.Image)
+	}
+
+	// A pinned project has a detached HEAD; use the branch it was on
+	return gitRemoteRevision(p.Path, p.branch)
+}
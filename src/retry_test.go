@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"timeout", errors.New("net/http: TLS handshake timeout"), true},
+		{"exit status", errors.New("exit status 1"), true},
+		{"missing program", &exec.Error{Name: "docker", Err: exec.ErrNotFound}, false},
+		{"missing path", fmt.Errorf("open: %w", os.ErrNotExist), false},
+		{"unauthorized", errors.New("exit status 1: Error response from daemon: unauthorized: authentication required"), false},
+		{"manifest unknown, any case", errors.New("exit status 1: MANIFEST UNKNOWN"), false},
+		{"git auth", errors.New("exit status 128: fatal: could not read Username for 'https://github.com'"), false},
+		{"stderr of an exit error", &exec.ExitError{ProcessState: &os.ProcessState{}, Stderr: []byte("fatal: not a git repository")}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := transient(tt.err); got != tt.want {
+				t.Errorf("transient(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandError(t *testing.T) {
+	base := errors.New("exit status 1")
+	tests := []struct {
+		name   string
+		err    error
+		output string
+		want   string
+	}{
+		{"no error", nil, "Pulling fs layer", ""},
+		{"no output", base, "", "exit status 1"},
+		{"last line", base, "Pulling from acme/web\nError response from daemon: manifest unknown\n", "exit status 1: Error response from daemon: manifest unknown"},
+		{"trailing blank lines", base, "fatal: not a git repository\n  \n", "exit status 1: fatal: not a git repository"},
+		{"blank output", base, " \n\n", "exit status 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := commandError(tt.err, []byte(tt.output))
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("commandError = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.want {
+				t.Fatalf("commandError = %v, want %q", err, tt.want)
+			}
+			if !errors.Is(err, base) {
+				t.Error("commandError does not wrap the original error")
+			}
+		})
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	flaky := errors.New("connection reset by peer")
+	denied := errors.New("denied: requested access to the resource is denied")
+	tests := []struct {
+		name      string
+		retry     *RetryConfig
+		errs      []error // Returned by successive attempts, then success
+		wantCalls int
+		wantErr   error
+	}{
+		{name: "no retry settings", errs: []error{flaky}, wantCalls: 1, wantErr: flaky},
+		{name: "succeeds right away", retry: &RetryConfig{Attempts: 3}, wantCalls: 1},
+		{name: "succeeds after retries", retry: &RetryConfig{Attempts: 3}, errs: []error{flaky, flaky}, wantCalls: 3},
+		{name: "attempts used up", retry: &RetryConfig{Attempts: 3}, errs: []error{flaky, flaky, flaky, flaky}, wantCalls: 3, wantErr: flaky},
+		{name: "permanent error", retry: &RetryConfig{Attempts: 3}, errs: []error{denied}, wantCalls: 1, wantErr: denied},
+		{name: "zero attempts", retry: &RetryConfig{}, errs: []error{flaky}, wantCalls: 1, wantErr: flaky},
+		{name: "negative attempts", retry: &RetryConfig{Attempts: -2}, wantCalls: 1},
+	}
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := withRetry(log, Project{Name: "web", Retry: tt.retry}, "Pull", func() error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("fn was called %d times, want %d", calls, tt.wantCalls)
+			}
+			if err != tt.wantErr {
+				t.Errorf("withRetry = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
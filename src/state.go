@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Entries kept per project, for deployment attempts and for checks that
+// found nothing to deploy.
+const (
+	maxHistoryPerProject = 50
+	maxChecksPerProject  = 50
+)
+
+// Outcomes of a single updateProject run.
+const (
+	outcomeUnchanged  = "unchanged"
+	outcomeDeployed   = "deployed"
+	outcomeRolledBack = "rolled back"
+	outcomeFailed     = "failed"
+	outcomeSuspended  = "suspended"
+)
+
+// Stages of updateProject, recorded so failures can be attributed.
+const (
+	stageCheck       = "check"
+	stagePull        = "pull"
+	stageBuild       = "build"
+	stageRestart     = "restart"
+	stageHealthcheck = "healthcheck"
+)
+
+// UpdateResult describes one run of updateProject for a project.
+type UpdateResult struct {
+	Project       string     `json:"project"`
+	Run           string     `json:"run,omitempty"` // ID attached to every log line of this run
+	Type          string     `json:"type"`
+	Outcome       string     `json:"outcome"`
+	Stage         string     `json:"stage,omitempty"`
+	OldRevision   string     `json:"oldRevision,omitempty"`
+	NewRevision   string     `json:"newRevision,omitempty"`
+	StartedAt     time.Time  `json:"startedAt"`
+	Duration      float64    `json:"durationSeconds"`
+	PullDuration  float64    `json:"pullDurationSeconds,omitempty"`
+	BuildDuration float64    `json:"buildDurationSeconds,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	Changelog     *Changelog `json:"changelog,omitempty"`
+	Logs          string     `json:"logs,omitempty"` // Command that shows the daemon output of this run
+}
+
+// ProjectState is the latest known state of a project.
+type ProjectState struct {
+	Revision    string    `json:"revision,omitempty"` // Last successfully deployed revision
+	LastCheck   time.Time `json:"lastCheck,omitzero"`
+	LastDeploy  time.Time `json:"lastDeploy,omitzero"`
+	LastOutcome string    `json:"lastOutcome,omitempty"`
+	LastError   string    `json:"lastError,omitempty"`
+	Pinned      string    `json:"pinned,omitempty"` // Revision set by rollback, kept until unpinned
+	Branch      string    `json:"branch,omitempty"` // Branch checked out before a git project was pinned
+	Paused      bool      `json:"paused,omitempty"` // Set through the control API, skipped by the daemon
+
+	Failures          int    `json:"failures,omitempty"`          // Consecutive failed runs
+	Suspended         bool   `json:"suspended,omitempty"`         // Circuit breaker is open
+	SuspendedRevision string `json:"suspendedRevision,omitempty"` // Source revision seen when the breaker opened
+}
+
+// State is persisted between daemon cycles and restarts.
+type State struct {
+	Projects map[string]*ProjectState `json:"projects"`
+	History  []UpdateResult           `json:"history"` // Checks and deployment attempts, oldest first
+}
+
+var stateMu sync.Mutex
+
+// stateDir returns where the state and control socket live. Regular users
+// running their own config keep them in ~/.local/state/updatectrl.
+func stateDir() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("USERPROFILE"), "updatectrl")
+	}
+	if userMode() && configPath() != systemConfigPath {
+		return filepath.Join(xdgDir("XDG_STATE_HOME", ".local/state"), "updatectrl")
+	}
+	return "/var/lib/updatectrl"
+}
+
+func statePath() string {
+	return filepath.Join(stateDir(), "state.json")
+}
+
+// loadState reads the state file, returning an empty state if it doesn't exist yet.
+func loadState() (*State, error) {
+	s := &State{Projects: map[string]*ProjectState{}}
+	data, err := os.ReadFile(statePath())
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("could not parse state: %w", err)
+	}
+	if s.Projects == nil {
+		s.Projects = map[string]*ProjectState{}
+	}
+	return s, nil
+}
+
+// save writes the state atomically so readers never see a partial file.
+func (s *State) save() error {
+	if err := os.MkdirAll(stateDir(), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := statePath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, statePath())
+}
+
+func (s *State) project(name string) *ProjectState {
+	ps, ok := s.Projects[name]
+	if !ok {
+		ps = &ProjectState{}
+		s.Projects[name] = ps
+	}
+	return ps
+}
+
+// isCheck reports whether a history entry is a check that didn't attempt a
+// deployment.
+func (r UpdateResult) isCheck() bool {
+	return r.Outcome == outcomeUnchanged || r.Outcome == outcomeSuspended
+}
+
+// projectHistory returns the recorded deployments of a project, newest first,
+// and with checks also the checks that found nothing to deploy. An empty name
+// returns the history of all projects.
+func (s *State) projectHistory(name string, checks bool) []UpdateResult {
+	var history []UpdateResult
+	for i := len(s.History) - 1; i >= 0; i-- {
+		if s.History[i].isCheck() && !checks {
+			continue
+		}
+		if name == "" || s.History[i].Project == name {
+			history = append(history, s.History[i])
+		}
+	}
+	return history
+}
+
+// updateState loads the state, applies fn and saves it again. The state is
+// locked meanwhile, also against other updatectrl processes.
+func updateState(fn func(s *State)) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	unlock, err := lockState()
+	if err != nil {
+		return fmt.Errorf("could not lock state: %w", err)
+	}
+	defer unlock()
+
+	s, err := loadState()
+	if err != nil {
+		return err
+	}
+	fn(s)
+	return s.save()
+}
+
+// recordResult stores the outcome of a run as the latest state of the
+// project and appends it to the history.
+func recordResult(log *slog.Logger, res UpdateResult) {
+	err := updateState(func(s *State) {
+		ps := s.project(res.Project)
+		ps.LastCheck = res.StartedAt
+		ps.LastOutcome = res.Outcome
+		ps.LastError = res.Error
+
+		switch res.Outcome {
+		case outcomeDeployed, outcomeRolledBack:
+			ps.Revision = res.NewRevision
+			ps.LastDeploy = res.StartedAt
+		case outcomeUnchanged:
+			if res.NewRevision != "" {
+				ps.Revision = res.NewRevision
+			} else if res.OldRevision != "" {
+				ps.Revision = res.OldRevision
+			}
+		}
+
+		s.History = append(s.History, res)
+		pruneHistory(s, res.Project)
+	})
+	if err != nil {
+		log.Error("Failed to save state", "error", err)
+	}
+}
+
+// pruneHistory keeps only the most recent entries of a project. Checks are
+// counted separately, so frequent checks don't push out deployments.
+func pruneHistory(s *State, name string) {
+	checks, deployments := 0, 0
+	for _, h := range s.History {
+		if h.Project != name {
+			continue
+		}
+		if h.isCheck() {
+			checks++
+		} else {
+			deployments++
+		}
+	}
+	dropChecks := max(checks-maxChecksPerProject, 0)
+	dropDeployments := max(deployments-maxHistoryPerProject, 0)
+	if dropChecks == 0 && dropDeployments == 0 {
+		return
+	}
+
+	kept := s.History[:0]
+	for _, h := range s.History {
+		if h.Project == name && h.isCheck() && dropChecks > 0 {
+			dropChecks--
+			continue
+		}
+		if h.Project == name && !h.isCheck() && dropDeployments > 0 {
+			dropDeployments--
+			continue
+		}
+		kept = append(kept, h)
+	}
+	s.History = kept
+}
+
+// logsPointer returns a command showing the daemon output between start and end.
+func logsPointer(start, end time.Time) string {
+	const layout = "2006-01-02 15:04:05"
+	since := start.Format(layout)
+	until := end.Add(time.Second).Format(layout)
+
+	if isRunningInDocker() {
+		hostname, _ := os.Hostname()
+		return fmt.Sprintf("docker logs %s --since %s --until %s", hostname, start.Format(time.RFC3339), end.Add(time.Second).Format(time.RFC3339))
+	}
+	if runtime.GOOS == "windows" {
+		return ""
+	}
+	if userUnitInstalled() {
+		return fmt.Sprintf("journalctl --user -u updatectrl --since %q --until %q", since, until)
+	}
+	return fmt.Sprintf("journalctl -u updatectrl --since %q --until %q", since, until)
+}
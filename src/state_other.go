@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package main
+
+// lockState is a no-op where flock isn't available; changes within one
+// process are still serialized by stateMu.
+func lockState() (unlock func(), err error) {
+	return func() {}, nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// ProjectStatus is the live state of a project as shown by `status`.
+type ProjectStatus struct {
+	Name            string    `json:"name"`
+	Type            string    `json:"type"`
+	Revision        string    `json:"revision,omitempty"`
+	Pinned          string    `json:"pinned,omitempty"`
+	UpdateAvailable *bool     `json:"updateAvailable"` // nil when the upstream could not be checked
+	LastCheck       time.Time `json:"lastCheck,omitzero"`
+	LastDeploy      time.Time `json:"lastDeploy,omitzero"`
+	LastOutcome     string    `json:"lastOutcome,omitempty"`
+	LastError       string    `json:"lastError,omitempty"`
+	Process         string    `json:"process,omitempty"`
+	Paused          bool      `json:"paused,omitempty"`
+	Suspended       bool      `json:"suspended,omitempty"` // Circuit breaker is open
+}
+
+// recordedStatus returns the status of a project as recorded in the state,
+// without contacting the registry, git remote or process manager.
+func recordedStatus(p Project, s *State) ProjectStatus {
+	status := ProjectStatus{Name: p.Name, Type: p.Type}
+	if ps, ok := s.Projects[p.Name]; ok {
+		status.Revision = ps.Revision
+		status.LastCheck = ps.LastCheck
+		status.LastDeploy = ps.LastDeploy
+		status.LastOutcome = ps.LastOutcome
+		status.LastError = ps.LastError
+		status.Pinned = ps.Pinned
+		status.Paused = ps.Paused
+		status.Suspended = ps.Suspended
+	}
+	return status
+}
+
+func getProjectStatus(p Project, s *State) ProjectStatus {
+	status := recordedStatus(p, s)
+	if ps, ok := s.Projects[p.Name]; ok {
+		p.branch = ps.Branch
+	}
+
+	if current, err := localRevision(p); err == nil && current != "" {
+		status.Revision = current
+		if latest, err := sourceRevision(p); err == nil && latest != "" {
+			available := latest != current
+			status.UpdateAvailable = &available
+		}
+	}
+	status.Process = processState(p)
+	return status
+}
+
+// localRevision returns the revision currently present on this machine:
+// the local image digest for image projects, the checked out commit otherwise.
+func localRevision(p Project) (string, error) {
+	if p.Type == "image" {
+		digest, err := getImageDigest(p.Image)
+		return digestHash(digest), err
+	}
+	return gitRevision(p.Path)
+}
+
+// processState reports whether the container or process behind a project is running.
+func processState(p Project) string {
+	switch p.Type {
+	case "image":
+		containerName := p.ContainerName
+		if containerName == "" {
+			containerName = p.Name
+		}
+		output, err := exec.Command("docker", "inspect", "-f", "{{.State.Status}}", containerName).Output()
+		if err != nil {
+			return "not found"
+		}
+		return strings.TrimSpace(string(output))
+	case "docker":
+		cmd := exec.Command("docker", "compose", "ps", "--format", "{{.State}}")
+		cmd.Dir = p.Path
+		output, err := cmd.Output()
+		if err != nil {
+			return "unknown"
+		}
+		states := strings.Fields(string(output))
+		running := 0
+		for _, state := range states {
+			if state == "running" {
+				running++
+			}
+		}
+		return fmt.Sprintf("%d/%d running", running, len(states))
+	case "pm2":
+		output, err := exec.Command("pm2", "jlist").Output()
+		if err != nil {
+			return "unknown"
+		}
+		var processes []struct {
+			Name   string `json:"name"`
+			PM2Env struct {
+				Status string `json:"status"`
+			} `json:"pm2_env"`
+		}
+		if err := json.Unmarshal(output, &processes); err != nil {
+			return "unknown"
+		}
+		for _, proc := range processes {
+			if proc.Name == p.Name {
+				return proc.PM2Env.Status
+			}
+		}
+		return "not found"
+	}
+	return ""
+}
+
+func shortRevision(rev string) string {
+	rev = strings.TrimPrefix(rev, "sha256:")
+	if len(rev) > 12 {
+		return rev[:12]
+	}
+	return rev
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the deployed revision and state of each project",
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		if live, _ := cmd.Flags().GetBool("live"); live {
+			printLiveStatus(output)
+			return
+		}
+		config := loadConfig()
+
+		s, err := loadState()
+		if err != nil {
+			fmt.Println("Failed to read state:", err)
+			os.Exit(1)
+		}
+
+		statuses := []ProjectStatus{}
+		for _, p := range config.Projects {
+			statuses = append(statuses, getProjectStatus(p, s))
+		}
+
+		if output == "json" {
+			data, _ := json.MarshalIndent(statuses, "", "  ")
+			fmt.Println(string(data))
+			return
+		}
+
+		if config.Source != nil {
+			printConfigStatus(config.status())
+			fmt.Println()
+		}
+		printStatusTable(statuses)
+	},
+}
+
+// ConfigStatus is the revision of a configuration loaded from git.
+type ConfigStatus struct {
+	Repo     string `json:"repo"`
+	Branch   string `json:"branch,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Revision string `json:"revision"`
+}
+
+func (c Config) status() *ConfigStatus {
+	if c.Source == nil {
+		return nil
+	}
+	return &ConfigStatus{Repo: redact(c.Source.Repo), Branch: c.Source.Branch, Path: c.Source.Path, Revision: c.revision}
+}
+
+func printConfigStatus(c *ConfigStatus) {
+	if c == nil {
+		return
+	}
+	source := c.Repo
+	if c.Branch != "" {
+		source += " (" + c.Branch + ")"
+	}
+	fmt.Printf("Config from %s at %s\n", source, shortRevision(c.Revision))
+}
+
+func printStatusTable(statuses []ProjectStatus) {
+	if len(statuses) == 0 {
+		fmt.Println("No projects configured.")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTYPE\tREVISION\tUPDATE\tLAST CHECK\tLAST DEPLOY\tOUTCOME\tPROCESS")
+	for _, st := range statuses {
+		update := "unknown"
+		if st.UpdateAvailable != nil {
+			update = "no"
+			if *st.UpdateAvailable {
+				update = "yes"
+			}
+		}
+		outcome := st.LastOutcome
+		if outcome == "" {
+			outcome = "-"
+		}
+		if st.Paused {
+			outcome += " (paused)"
+		} else if st.Suspended {
+			outcome += " (suspended)"
+		}
+		process := st.Process
+		if process == "" {
+			process = "-"
+		}
+		revision := shortRevision(st.Revision)
+		if revision == "" {
+			revision = "-"
+		}
+		if st.Pinned != "" {
+			revision += " (pinned)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", st.Name, st.Type, revision, update,
+			formatTime(st.LastCheck), formatTime(st.LastDeploy), outcome, process)
+	}
+	w.Flush()
+}
+
+func init() {
+	statusCmd.Flags().StringP("output", "o", "table", "Output format (table or json)")
+	statusCmd.Flags().Bool("live", false, "Ask the running daemon for its current state")
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// resolveTemplates merges the templates each project extends and then the
+// global defaults into it. Settings of the project win over its templates,
+// which win over the defaults; env variables are merged by name.
+func resolveTemplates(c *Config, src configSource) ConfigErrors {
+	var errs ConfigErrors
+	for i := range c.Projects {
+		p := &c.Projects[i]
+		ref := src.project(i)
+		label := p.Name
+		if label == "" {
+			label = fmt.Sprintf("%d", i+1)
+		}
+
+		bases, err := c.templateChain(p.Extends, nil)
+		if err != nil {
+			errs = append(errs, ConfigError{File: ref.file, Line: lineOf(ref.node, "extends"), Msg: fmt.Sprintf("project %s: %v", label, err)})
+			continue
+		}
+		for _, base := range bases {
+			mergeProject(p, base)
+		}
+		mergeProject(p, c.Defaults)
+	}
+	return errs
+}
+
+// templateChain returns the templates names extend, each followed by the
+// templates it extends in turn, in order of precedence.
+func (c Config) templateChain(names templateNames, seen []string) ([]Project, error) {
+	var chain []Project
+	for _, name := range names {
+		if slices.Contains(seen, name) {
+			return nil, fmt.Errorf("templates extend each other: %s", strings.Join(append(seen, name), " → "))
+		}
+		t, ok := c.Templates[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown template %q", name)
+		}
+		bases, err := c.templateChain(t.Extends, append(seen, name))
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, t)
+		chain = append(chain, bases...)
+	}
+	return chain, nil
+}
+
+// templateNames is the extends setting, a template name or a list of them.
+type templateNames []string
+
+func (t *templateNames) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*t = templateNames{value.Value}
+		return nil
+	}
+	return value.Decode((*[]string)(t))
+}
+
+// mergeProject sets the settings of p that are unset from base. Maps are
+// merged by key, keeping the values of p. The name and extends are never
+// inherited.
+func mergeProject(p *Project, base Project) {
+	dst := reflect.ValueOf(p).Elem()
+	src := reflect.ValueOf(base)
+	for i := range dst.NumField() {
+		field, value := dst.Field(i), src.Field(i)
+		name := dst.Type().Field(i).Name
+		if !field.CanSet() || value.IsZero() || name == "Name" || name == "Extends" {
+			continue
+		}
+		switch field.Kind() {
+		case reflect.Map:
+			merged := reflect.MakeMap(field.Type())
+			for _, m := range []reflect.Value{value, field} {
+				iter := m.MapRange()
+				for iter.Next() {
+					merged.SetMapIndex(iter.Key(), iter.Value())
+				}
+			}
+			field.Set(merged)
+		case reflect.Pointer:
+			if field.IsNil() {
+				// Copy, as the defaults are applied to each project
+				clone := reflect.New(value.Elem().Type())
+				clone.Elem().Set(value.Elem())
+				field.Set(clone)
+			}
+		default:
+			if field.IsZero() {
+				field.Set(value)
+			}
+		}
+	}
+}
+
+// resolvedYAML returns the effective configuration of a project as YAML,
+// leaving out settings that aren't set.
+func resolvedYAML(p Project) (string, error) {
+	var node yaml.Node
+	if err := node.Encode(p); err != nil {
+		return "", err
+	}
+	pruneEmpty(&node)
+
+	var b strings.Builder
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return "", err
+	}
+	return b.String(), enc.Close()
+}
+
+func pruneEmpty(n *yaml.Node) {
+	if n.Kind != yaml.MappingNode {
+		for _, child := range n.Content {
+			pruneEmpty(child)
+		}
+		return
+	}
+	content := n.Content[:0]
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		value := n.Content[i+1]
+		pruneEmpty(value)
+		if value.Kind == yaml.ScalarNode && (value.Value == "" || value.Tag == "!!null") || value.Kind != yaml.ScalarNode && len(value.Content) == 0 {
+			continue
+		}
+		content = append(content, n.Content[i], value)
+	}
+	n.Content = content
+}
@@ -1,20 +1,120 @@
 package main
 
 type Project struct {
-	Name          string            `yaml:"name"`
-	Path          string            `yaml:"path"`
-	Repo          string            `yaml:"repo"`
-	Type          string            `yaml:"type"`
-	BuildCommand  string            `yaml:"buildCommand"`
-	Image         string            `yaml:"image"`         // Docker image to pull (e.g., "ghcr.io/user/vite-app:main")
-	Port          string            `yaml:"port"`          // Port mapping (e.g., "80:80" or "3000:80")
-	Env           map[string]string `yaml:"env"`           // Environment variables
-	ContainerName string            `yaml:"containerName"` // Optional custom container name
+	Name           string                `yaml:"name"`
+	Extends        templateNames         `yaml:"extends"` // Templates whose settings are used where this project sets none
+	Path           string                `yaml:"path"`
+	Repo           string                `yaml:"repo"`
+	Type           string                `yaml:"type"`
+	BuildCommand   string                `yaml:"buildCommand"`
+	Image          string                `yaml:"image"`              // Docker image to pull (e.g., "ghcr.io/user/vite-app:main")
+	Port           string                `yaml:"port"`               // Port mapping (e.g., "80:80" or "3000:80")
+	Env            map[string]string     `yaml:"env"`                // Environment variables
+	EnvFile        string                `yaml:"envFile"`            // File of KEY=value lines merged into Env
+	ContainerName  string                `yaml:"containerName"`      // Optional custom container name
+	RestartPolicy  string                `yaml:"restartPolicy"`      // Docker restart policy of the container, unless-stopped by default
+	Schedule       int                   `yaml:"schedule,omitempty"` // Seconds between checks of this project, if longer than the interval
+	Healthcheck    string                `yaml:"healthcheck"`        // URL that must respond after a deployment
+	Retry          *RetryConfig          `yaml:"retry"`              // Optional override of the global retry settings
+	CircuitBreaker *CircuitBreakerConfig `yaml:"circuitBreaker"`     // Optional override of the global circuit breaker
+	Notifications  []NotificationConfig  `yaml:"notifications"`      // Sent in addition to the matching global notifications
+
+	pin    string // Revision the project is pinned to by rollback, if any
+	branch string // Branch a pinned git project returns to once unpinned
+	target string // Exact revision to deploy, set when applying a saved plan
+}
+
+// RetryConfig controls how transient steps (registry checks, pulls) are retried.
+type RetryConfig struct {
+	Attempts int `yaml:"attempts"` // Total attempts per step, including the first one
+	Delay    int `yaml:"delay"`    // Initial backoff in seconds, doubled after each failure
+	MaxDelay int `yaml:"maxDelay"` // Upper bound for the backoff in seconds
+}
+
+// NotificationConfig sends events of projects to a chat or webhook endpoint,
+// or by email.
+type NotificationConfig struct {
+	Type     string            `yaml:"type"`     // slack, discord, teams, webhook, email, ntfy, gotify or mqtt
+	URL      string            `yaml:"url"`      // Incoming webhook URL, ntfy or Gotify server, or MQTT broker
+	Events   []string          `yaml:"events"`   // Events to send; all by default, only failed for email
+	Projects []string          `yaml:"projects"` // Projects whose events are sent, for global notifications; all by default
+	Headers  map[string]string `yaml:"headers"`  // Extra HTTP headers, e.g. for authentication
+
+	SMTP   *SMTPConfig `yaml:"smtp"`   // Mail server, for email
+	To     []string    `yaml:"to"`     // Recipients, for email
+	Digest string      `yaml:"digest"` // Time of day ("08:00") to email a daily digest; disabled when empty
+
+	Topic    string         `yaml:"topic"`    // ntfy or MQTT topic; {project} and {event} are replaced
+	Token    string         `yaml:"token"`    // ntfy access token or Gotify application token
+	Priority map[string]int `yaml:"priority"` // Overrides the priority of events, for ntfy, Gotify and MQTT
+}
+
+// SMTPConfig is the mail server email notifications are sent through.
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"` // Defaults to 587, 465 with tls: tls, 25 with tls: none
+	TLS      string `yaml:"tls"`  // starttls (default), tls or none
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"` // Defaults to the username
+}
+
+// WebhookConfig enables the listener for push and registry webhooks.
+type WebhookConfig struct {
+	Listen string `yaml:"listen"` // Address to listen on, e.g. ":9000"; empty disables webhooks
+	Secret string `yaml:"secret"` // HMAC secret (GitHub, Gitea), token (GitLab) or ?token= value (Docker Hub)
+}
+
+// MetricsConfig enables the Prometheus metrics endpoint.
+type MetricsConfig struct {
+	Listen string `yaml:"listen"` // Address to serve /metrics on, e.g. ":9101"; empty disables it
+}
+
+// RedactConfig controls which values are masked in logs and notifications.
+type RedactConfig struct {
+	Env     []string `yaml:"env"`     // Env var names whose values are masked, as glob patterns; secret-like names by default
+	Secrets []string `yaml:"secrets"` // Additional strings to mask
+}
+
+// CircuitBreakerConfig suspends a project after repeated failed deployments.
+type CircuitBreakerConfig struct {
+	Threshold int `yaml:"threshold"` // Consecutive failures before suspending; negative disables
+}
+
+// SourceConfig loads the configuration from a git repository, which is
+// pulled every cycle.
+type SourceConfig struct {
+	Repo   string `yaml:"repo"`
+	Branch string `yaml:"branch"` // Defaults to the default branch of the repository
+	Path   string `yaml:"path"`   // Config file in the repository, updatectrl.yaml by default
+}
+
+// DiscoveryConfig controls the discovery of running containers to update.
+type DiscoveryConfig struct {
+	Enabled       *bool    `yaml:"enabled"`       // Defaults to true when running in Docker
+	LabelRequired bool     `yaml:"labelRequired"` // Only discover containers labeled updatectrl.enable=true
+	Include       []string `yaml:"include"`       // Only discover containers whose name or image matches, as glob patterns
+	Exclude       []string `yaml:"exclude"`       // Never discover containers whose name or image matches
 }
 
 type Config struct {
+	Source *SourceConfig `yaml:"source"` // Load the rest of the configuration from git
 	// Deprecated: Use Interval instead.
-	IntervalMinutes int       `yaml:"intervalMinutes"`
-	Interval        int       `yaml:"interval"`
-	Projects        []Project `yaml:"projects"`
+	IntervalMinutes int                  `yaml:"intervalMinutes"`
+	Interval        int                  `yaml:"interval"`
+	Retry           RetryConfig          `yaml:"retry"`
+	CircuitBreaker  CircuitBreakerConfig `yaml:"circuitBreaker"`
+	Webhook         WebhookConfig        `yaml:"webhook"`
+	Metrics         MetricsConfig        `yaml:"metrics"`
+	Redact          RedactConfig         `yaml:"redact"`
+	Notifications   []NotificationConfig `yaml:"notifications"`
+	Include         []string             `yaml:"include"`   // Extra project files, relative to the config
+	Defaults        Project              `yaml:"defaults"`  // Settings of all projects, where they and their templates set none
+	Templates       map[string]Project   `yaml:"templates"` // Named settings projects can extend
+	Discovery       DiscoveryConfig      `yaml:"discovery"`
+	Projects        []Project            `yaml:"projects"`
+
+	secrets  []string  // Values read with valueFrom, masked in logs
+	revision string    // Commit of the config repository, with Source
+	declared []Project // Projects of the config file, before discovered containers are merged in
 }
@@ -2,15 +2,19 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"os/exec"
 	"runtime"
 	"strings"
+	"time"
 )
 
 var version = "0.1.0"
 
-func runBuildCommand(command, dir string) error {
+// runBuildCommand runs a shell command in dir, writing its output to out.
+func runBuildCommand(command, dir string, out io.Writer) error {
 	var cmd *exec.Cmd
 	if runtime.GOOS == "windows" {
 		cmd = exec.Command("cmd", "/C", command)
@@ -18,16 +22,23 @@ func runBuildCommand(command, dir string) error {
 		cmd = exec.Command("bash", "-c", command)
 	}
 	cmd.Dir = dir
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = out
+	cmd.Stderr = out
 	return cmd.Run()
 }
 
-func updateProject(p Project) {
+// updateProject brings a project up to date, filling res with the stage,
+// revisions and outcome as it goes.
+func updateProject(log *slog.Logger, p Project, res *UpdateResult) error {
+	res.Stage = stageCheck
 	if p.Type == "image" {
 		if p.Image == "" {
-			fmt.Println("✘ No image specified for project:", p.Name)
-			return
+			log.Error("No image specified for project")
+			return fmt.Errorf("no image specified")
+		}
+
+		if p.pin != "" {
+			return deployPinnedImage(log, p, res)
 		}
 
 		containerName := p.ContainerName
@@ -42,21 +53,31 @@ func updateProject(p Project) {
 		// Get current local image digest
 		currentDigest, err := getImageDigest(p.Image)
 		if err != nil || currentDigest == "" {
-			fmt.Println("→ Local image not found or no digest available")
+			logStep(log, "Local image not found or no digest available")
 			currentDigest = ""
 		} else {
-			fmt.Println("→ Current local digest:", currentDigest)
+			logStep(log, "Current local digest", "digest", currentDigest)
 		}
+		res.OldRevision = digestHash(currentDigest)
 
-		// Get remote registry digest
-		remoteDigest, err := getRemoteImageDigest(p.Image)
-		if err != nil {
-			fmt.Println("→ Could not check remote digest:", err)
+		// Get remote registry digest, unless a saved plan fixed the target
+		remoteDigest := p.target
+		var remoteErr error
+		if remoteDigest == "" {
+			remoteErr = withRetry(log, p, "Remote digest check", func() error {
+				var err error
+				remoteDigest, err = getRemoteImageDigest(p.Image)
+				return countRegistryError(p, err)
+			})
+		}
+		if remoteErr != nil {
+			log.Warn("Could not check remote digest", "error", remoteErr)
 			// If we can't check remote, pull anyway to be safe
 			remoteDigest = ""
 		} else {
-			fmt.Println("→ Remote registry digest:", remoteDigest)
+			logStep(log, "Remote registry digest", "digest", remoteDigest)
 		}
+		res.NewRevision = remoteDigest
 
 		// Determine if image needs update
 		imageNeedsUpdate := false
@@ -67,85 +88,299 @@ func updateProject(p Project) {
 			// Couldn't check remote, assume update needed
 			imageNeedsUpdate = true
 		} else {
-			// Extract just the sha256 hash from currentDigest if it's a full repo digest
-			// currentDigest might be like "ghcr.io/user/app@sha256:abc123"
-			// remoteDigest is like "sha256:abc123"
-			currentHash := currentDigest
-			if strings.Contains(currentDigest, "@") {
-				parts := strings.Split(currentDigest, "@")
-				if len(parts) == 2 {
-					currentHash = parts[1]
-				}
-			}
-
 			// Compare hashes
-			imageNeedsUpdate = currentHash != remoteDigest
+			imageNeedsUpdate = digestHash(currentDigest) != remoteDigest
 		}
 
 		if !imageNeedsUpdate && containerRunning {
-			fmt.Println("● Image already up to date and container running:", p.Name)
-			return
+			if runsImage(containerName, p.Image) {
+				logUnchanged(log, "Image already up to date and container running")
+				return nil
+			}
+			// E.g. still running the revision it was pinned to before unpin
+			logStep(log, "Container runs another image, recreating it")
+			if revision := containerRevision(containerName); revision != "" {
+				res.OldRevision = revision
+			}
 		}
 
 		if imageNeedsUpdate {
-			fmt.Println("→ Pulling latest image:", p.Image)
-			if err := pullDockerImage(p.Image); err != nil {
-				fmt.Println("✘ Failed to pull image:", err)
-				return
+			res.Stage = stagePull
+			oldLabels := imageLabels(p.Image)
+			logStep(log, "Pulling latest image", "image", p.Image)
+			var pullTime time.Duration
+			err := withRetry(log, p, "Image pull", timed(&pullTime, func() error { return countRegistryError(p, pullProjectImage(log, p)) }))
+			res.PullDuration = pullTime.Seconds()
+			if err != nil {
+				log.Error("Failed to pull image", "error", err)
+				return fmt.Errorf("pull image: %w", err)
 			}
-			fmt.Println("✓ New image version detected:", p.Name)
+			if digest, err := getImageDigest(p.Image); err == nil && digest != "" {
+				res.NewRevision = digestHash(digest)
+			}
+			logSuccess(log, "New image version detected")
+			res.Changelog = imageChangelog(oldLabels, imageLabels(p.Image))
+			logChangelog(log, res.Changelog)
 		} else if !containerRunning {
-			fmt.Println("→ Container not running, starting it:", p.Name)
+			logStep(log, "Container not running, starting it")
 		}
 
-		if err := restartDockerContainer(p); err != nil {
-			fmt.Println("✘ Failed to restart container:", err)
-			return
+		res.Stage = stageRestart
+		if err := restartDockerContainer(log, p); err != nil {
+			log.Error("Failed to restart container", "error", err)
+			return fmt.Errorf("restart container: %w", err)
 		}
-		fmt.Println("✓ Container started successfully")
+		logSuccess(log, "Container started successfully")
 
-		return
+		res.Stage = stageHealthcheck
+		if err := waitHealthy(log, p); err != nil {
+			return fmt.Errorf("healthcheck: %w", err)
+		}
+		res.Outcome = outcomeDeployed
+		return nil
 	}
 
 	if _, err := os.Stat(p.Path); os.IsNotExist(err) {
-		fmt.Println("✘ Path not found:", p.Path)
-		return
+		log.Error("Path not found", "path", p.Path)
+		return fmt.Errorf("path not found: %s", p.Path)
 	}
 
-	fmt.Println("→ Pulling latest changes for", p.Name)
-	gitPull := exec.Command("git", "-C", p.Path, "pull")
-	output, err := gitPull.CombinedOutput()
+	res.OldRevision, _ = gitRevision(p.Path)
+
+	res.Stage = stagePull
+	pull := pullGitProject
+	if p.pin != "" {
+		pull = checkoutPinnedRevision
+	}
+	changed, err := pull(log, p, res)
 	if err != nil {
-		fmt.Println("✘ Git pull failed:", err)
-		return
+		return err
+	}
+	if !changed {
+		return nil
 	}
-	fmt.Print(string(output))
 
-	if strings.Contains(string(output), "Already up to date.") {
-		fmt.Println("● No new commits for", p.Name)
-		return
+	if c, err := gitChangelog(p.Path, res.OldRevision, res.NewRevision); err != nil {
+		log.Warn("Could not list changes", "error", err)
+	} else {
+		res.Changelog = c
+		logChangelog(log, c)
 	}
 
 	if p.BuildCommand != "" {
-		fmt.Println("→ Running build command for", p.Name)
-		if err := runBuildCommand(p.BuildCommand, p.Path); err != nil {
-			fmt.Println("✘ Build failed for", p.Name, ":", err)
-			return
+		res.Stage = stageBuild
+		logStep(log, "Running build command")
+		buildStart := time.Now()
+		err := runBuildCommand(p.BuildCommand, p.Path, logWriter(log, "build"))
+		res.BuildDuration = time.Since(buildStart).Seconds()
+		if err != nil {
+			log.Error("Build failed", "error", err)
+			return fmt.Errorf("build: %w", err)
 		}
 	}
 
+	res.Stage = stageRestart
 	switch p.Type {
 	case "pm2":
-		fmt.Println("→ Restarting PM2 process:", p.Name)
+		logStep(log, "Restarting PM2 process")
 		cmd := exec.Command("pm2", "restart", p.Name)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		cmd.Run()
+		cmd.Stdout = logWriter(log, "pm2")
+		cmd.Stderr = cmd.Stdout
+		if err := cmd.Run(); err != nil {
+			log.Error("Failed to restart PM2 process", "error", err)
+			return fmt.Errorf("pm2 restart: %w", err)
+		}
 	case "docker":
 		// Build command already run above
 	case "static":
 		// No additional action needed
 	default:
-		fmt.Println("Unknown type:", p.Type)
+		log.Error("Unknown type", "type", p.Type)
+		return fmt.Errorf("unknown type: %s", p.Type)
+	}
+
+	res.Stage = stageHealthcheck
+	if err := waitHealthy(log, p); err != nil {
+		return fmt.Errorf("healthcheck: %w", err)
+	}
+	res.Outcome = outcomeDeployed
+	if p.pin != "" {
+		res.Outcome = outcomeRolledBack
+	}
+	return nil
+}
+
+// pullGitProject pulls the latest commits and reports whether anything changed.
+func pullGitProject(log *slog.Logger, p Project, res *UpdateResult) (bool, error) {
+	// A project that was pinned by rollback is left on a detached HEAD
+	if p.branch != "" && exec.Command("git", "-C", p.Path, "symbolic-ref", "-q", "HEAD").Run() != nil {
+		logStep(log, "Returning to branch", "branch", p.branch)
+		if output, err := exec.Command("git", "-C", p.Path, "checkout", p.branch).CombinedOutput(); err != nil {
+			log.Error("Git checkout failed", "error", err, "output", strings.TrimSpace(string(output)))
+			return false, fmt.Errorf("git checkout: %w", err)
+		}
+	}
+
+	// A saved plan fixes the exact commit to fast-forward to
+	pullArgs := []string{"-C", p.Path, "pull"}
+	if p.target != "" {
+		if err := exec.Command("git", "-C", p.Path, "fetch", "--quiet").Run(); err != nil {
+			log.Error("Git fetch failed", "error", err)
+			return false, fmt.Errorf("git fetch: %w", err)
+		}
+		pullArgs = []string{"-C", p.Path, "merge", "--ff-only", p.target}
+	}
+
+	logStep(log, "Pulling latest changes")
+	var output []byte
+	var pullTime time.Duration
+	err := withRetry(log, p, "Git pull", timed(&pullTime, func() error {
+		var err error
+		output, err = exec.Command("git", pullArgs...).CombinedOutput()
+		return commandError(err, output)
+	}))
+	res.PullDuration = pullTime.Seconds()
+	if err != nil {
+		log.Error("Git pull failed", "error", err)
+		return false, fmt.Errorf("git pull: %w", err)
+	}
+	logOutput(log, "git", output)
+	res.NewRevision, _ = gitRevision(p.Path)
+
+	if strings.Contains(string(output), "Already up to date.") && res.NewRevision == res.OldRevision {
+		logUnchanged(log, "No new commits")
+		return false, nil
+	}
+	return true, nil
+}
+
+// checkoutPinnedRevision checks out the commit a project is pinned to and
+// reports whether anything changed.
+func checkoutPinnedRevision(log *slog.Logger, p Project, res *UpdateResult) (bool, error) {
+	res.NewRevision = p.pin
+	if res.OldRevision == p.pin {
+		logUnchanged(log, "Pinned commit already checked out")
+		return false, nil
+	}
+
+	logStep(log, "Checking out pinned commit", "revision", shortRevision(p.pin))
+	err := withRetry(log, p, "Git fetch", func() error {
+		output, err := exec.Command("git", "-C", p.Path, "fetch", "--quiet").CombinedOutput()
+		return commandError(err, output)
+	})
+	if err != nil {
+		log.Error("Git fetch failed", "error", err)
+		return false, fmt.Errorf("git fetch: %w", err)
+	}
+	if output, err := exec.Command("git", "-C", p.Path, "checkout", "--detach", p.pin).CombinedOutput(); err != nil {
+		log.Error("Git checkout failed", "error", err, "output", strings.TrimSpace(string(output)))
+		return false, fmt.Errorf("git checkout: %w", err)
+	}
+	return true, nil
+}
+
+// pullProjectImage pulls the image of a project. When a saved plan fixed the
+// target digest, that exact digest is pulled and tagged as the project image.
+func pullProjectImage(log *slog.Logger, p Project) error {
+	if p.target == "" {
+		return pullDockerImage(log, p.Image)
+	}
+	ref := imageRepository(p.Image) + "@" + p.target
+	if err := pullDockerImage(log, ref); err != nil {
+		return err
+	}
+	return exec.Command("docker", "tag", ref, p.Image).Run()
+}
+
+// deployPinnedImage runs the image digest a project is pinned to.
+func deployPinnedImage(log *slog.Logger, p Project, res *UpdateResult) error {
+	ref := imageRepository(p.Image) + "@" + p.pin
+	res.NewRevision = p.pin
+
+	containerName := p.ContainerName
+	if containerName == "" {
+		containerName = p.Name
+	}
+	res.OldRevision = containerRevision(containerName)
+	if running, image := containerState(containerName); running && image == ref {
+		logUnchanged(log, "Pinned image already running")
+		return nil
+	}
+
+	res.Stage = stagePull
+	logStep(log, "Pulling pinned image", "image", ref)
+	var pullTime time.Duration
+	err := withRetry(log, p, "Image pull", timed(&pullTime, func() error { return countRegistryError(p, pullDockerImage(log, ref)) }))
+	res.PullDuration = pullTime.Seconds()
+	if err != nil {
+		log.Error("Failed to pull image", "error", err)
+		return fmt.Errorf("pull image: %w", err)
+	}
+
+	res.Stage = stageRestart
+	p.Image = ref
+	if err := restartDockerContainer(log, p); err != nil {
+		log.Error("Failed to restart container", "error", err)
+		return fmt.Errorf("restart container: %w", err)
+	}
+	logSuccess(log, "Container started successfully")
+
+	res.Stage = stageHealthcheck
+	if err := waitHealthy(log, p); err != nil {
+		return fmt.Errorf("healthcheck: %w", err)
+	}
+	res.Outcome = outcomeRolledBack
+	return nil
+}
+
+// imageRepository strips the tag and digest from an image reference.
+func imageRepository(image string) string {
+	if i := strings.Index(image, "@"); i != -1 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
+
+// digestHash extracts the sha256 hash from a repo digest such as
+// "ghcr.io/user/app@sha256:abc123".
+func digestHash(digest string) string {
+	if i := strings.LastIndex(digest, "@"); i != -1 {
+		return digest[i+1:]
+	}
+	return digest
+}
+
+// gitRemoteRevision returns the commit the upstream of branch, or of the
+// checked out branch if empty, points to on the
+// remote, without fetching anything.
+func gitRemoteRevision(path, branch string) (string, error) {
+	output, err := exec.Command("git", "-C", path, "rev-parse", "--abbrev-ref", "--symbolic-full-name", branch+"@{u}").Output()
+	if err != nil {
+		return "", fmt.Errorf("no upstream branch: %w", err)
+	}
+	remote, branch, ok := strings.Cut(strings.TrimSpace(string(output)), "/")
+	if !ok {
+		return "", fmt.Errorf("unexpected upstream %q", strings.TrimSpace(string(output)))
+	}
+
+	output, err = exec.Command("git", "-C", path, "ls-remote", remote, "refs/heads/"+branch).Output()
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(output))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("branch %s not found on %s", branch, remote)
+	}
+	return fields[0], nil
+}
+
+func gitRevision(path string) (string, error) {
+	output, err := exec.Command("git", "-C", path, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", err
 	}
+	return strings.TrimSpace(string(output)), nil
 }
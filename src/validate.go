@@ -0,0 +1,586 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var projectTypes = []string{"docker", "pm2", "static", "image"}
+
+var notificationTypes = []string{"slack", "discord", "teams", "webhook", "email", "ntfy", "gotify", "mqtt"}
+
+// imageReference matches [registry[:port]/]name[:tag][@digest].
+var imageReference = regexp.MustCompile(`^(?:[a-zA-Z0-9.-]+(?::[0-9]+)?/)?[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*(?::[\w][\w.-]{0,127})?(?:@sha256:[a-f0-9]{64})?$`)
+
+var restartPolicyPattern = regexp.MustCompile(`^(no|always|unless-stopped|on-failure(:[0-9]+)?)$`)
+
+// unknownField matches the errors yaml.v3 reports for unknown keys in
+// strict mode.
+var unknownField = regexp.MustCompile(`^line (\d+): field (\S+) not found in type main\.(\w+)$`)
+
+// ConfigError is a problem found in the configuration, at Line if known.
+type ConfigError struct {
+	File string // Included file the problem is in; empty for the main file
+	Line int
+	Msg  string
+}
+
+func (e ConfigError) Error() string {
+	switch {
+	case e.File != "" && e.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+	case e.File != "":
+		return fmt.Sprintf("%s: %s", e.File, e.Msg)
+	case e.Line > 0:
+		return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+	}
+	return e.Msg
+}
+
+// ConfigErrors is every problem found in a configuration file.
+type ConfigErrors []ConfigError
+
+func (errs ConfigErrors) Error() string {
+	lines := make([]string, len(errs))
+	for i, e := range errs {
+		lines[i] = e.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sort orders errors by file and line, those in the main file first.
+func (errs ConfigErrors) sort() {
+	slices.SortStableFunc(errs, func(a, b ConfigError) int {
+		if a.File != b.File {
+			return strings.Compare(a.File, b.File)
+		}
+		return a.Line - b.Line
+	})
+}
+
+// configSource records where the parts of a configuration were defined, so
+// errors can point at the right file and line.
+type configSource struct {
+	file     string     // Main config file
+	doc      *yaml.Node // Document of the main file
+	projects []yamlRef  // Definition of each project, in order
+}
+
+// yamlRef is a node and the included file it is in, empty for the main file.
+type yamlRef struct {
+	file string
+	node *yaml.Node
+}
+
+func (src configSource) project(i int) yamlRef {
+	if i < len(src.projects) {
+		return src.projects[i]
+	}
+	return yamlRef{}
+}
+
+// decodeStrict decodes data into v after resolving the ${VAR} and valueFrom
+// references in its values against the environment and dir. It returns the
+// document node for line numbers, the secrets read from files and the
+// unknown keys found, which don't keep the rest from being validated; err
+// is set when v couldn't be decoded. file is used in errors.
+func decodeStrict(file, dir string, data []byte, v any) (doc *yaml.Node, secrets []string, unknown ConfigErrors, err error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		if file != "" {
+			return nil, nil, nil, fmt.Errorf("%s: %w", file, err)
+		}
+		return nil, nil, nil, err
+	}
+	if len(root.Content) == 0 {
+		return nil, nil, nil, nil
+	}
+
+	// Unknown keys are looked for in the file as written, the values are
+	// decoded once the references are resolved
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(reflect.New(reflect.TypeOf(v).Elem()).Interface()); err != nil {
+		var configErrs ConfigErrors
+		if !errors.As(decodeErrors(file, err), &configErrs) {
+			return nil, nil, nil, decodeErrors(file, err)
+		}
+		for _, e := range configErrs {
+			if strings.HasPrefix(e.Msg, "unknown field") {
+				unknown = append(unknown, e)
+			}
+		}
+	}
+
+	secrets, errs := interpolate(file, dir, &root)
+	if err := root.Decode(v); err != nil {
+		var configErrs ConfigErrors
+		if !errors.As(decodeErrors(file, err), &configErrs) {
+			return nil, nil, nil, decodeErrors(file, err)
+		}
+		errs = append(errs, configErrs...)
+	}
+	if len(errs) > 0 {
+		errs = append(errs, unknown...)
+		errs.sort()
+		return nil, nil, nil, errs
+	}
+	return root.Content[0], secrets, unknown, nil
+}
+
+// parseConfig decrypts and decodes the main configuration file strictly,
+// merges the projects of its includes, and validates the result before applying the
+// defaults.
+func parseConfig(path string, data []byte) (Config, error) {
+	data, decrypted, err := decryptFile(path, data)
+	if err != nil {
+		return Config{}, err
+	}
+	var c Config
+	doc, secrets, errs, err := decodeStrict("", filepath.Dir(path), data, &c)
+	if err != nil {
+		return Config{}, err
+	}
+	c.secrets = append(decrypted, secrets...)
+	if c.Source != nil {
+		// The rest of the configuration comes from the repository
+		if errs = append(errs, validateSource(c, doc)...); len(errs) > 0 {
+			errs.sort()
+			return Config{}, errs
+		}
+		return c, nil
+	}
+
+	src := configSource{file: path, doc: doc}
+	projects := mappingValue(doc, "projects")
+	for i := range c.Projects {
+		src.projects = append(src.projects, yamlRef{node: sequenceItem(projects, i)})
+	}
+
+	files, err := includedFiles(path, c.Include)
+	if err != nil {
+		errs = append(errs, ConfigError{Line: lineOf(doc, "include"), Msg: err.Error()})
+		errs.sort()
+		return Config{}, errs
+	}
+	for _, file := range files {
+		included, refs, secrets, unknown, err := readInclude(file)
+		var configErrs ConfigErrors
+		if errors.As(err, &configErrs) {
+			errs = append(errs, configErrs...)
+			continue
+		} else if err != nil {
+			errs = append(errs, ConfigError{File: file, Msg: err.Error()})
+			continue
+		}
+		errs = append(errs, unknown...)
+		c.Projects = append(c.Projects, included...)
+		src.projects = append(src.projects, refs...)
+		c.secrets = append(c.secrets, secrets...)
+	}
+	errs = append(errs, resolveTemplates(&c, src)...)
+	errs = append(errs, loadEnvFiles(&c, src)...)
+
+	errs = append(errs, validateConfig(c, src)...)
+	if len(errs) > 0 {
+		errs.sort()
+		return Config{}, errs
+	}
+	applyDefaults(&c)
+	return c, nil
+}
+
+// decodeErrors turns the errors of the yaml decoder into ConfigErrors.
+func decodeErrors(file string, err error) error {
+	typeErr, ok := err.(*yaml.TypeError)
+	if !ok {
+		if file != "" {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		return err
+	}
+	var errs ConfigErrors
+	for _, msg := range typeErr.Errors {
+		if m := unknownField.FindStringSubmatch(msg); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			msg = fmt.Sprintf("unknown field %q", m[2])
+			if m[3] == "includeFile" {
+				msg += ", included files can only define projects"
+			}
+			errs = append(errs, ConfigError{File: file, Line: line, Msg: msg})
+			continue
+		}
+		line := 0
+		if rest, ok := strings.CutPrefix(msg, "line "); ok {
+			if n, msg2, ok := strings.Cut(rest, ": "); ok {
+				line, _ = strconv.Atoi(n)
+				msg = msg2
+			}
+		}
+		errs = append(errs, ConfigError{File: file, Line: line, Msg: msg})
+	}
+	return errs
+}
+
+// mappingValue returns the value of key in a mapping node, nil if absent.
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// lineOf returns the line of key in the mapping, or of the mapping itself.
+func lineOf(n *yaml.Node, key string) int {
+	if v := mappingValue(n, key); v != nil {
+		return v.Line
+	}
+	if n != nil {
+		return n.Line
+	}
+	return 0
+}
+
+// sequenceItem returns item i of a sequence node, nil if absent.
+func sequenceItem(n *yaml.Node, i int) *yaml.Node {
+	if n == nil || n.Kind != yaml.SequenceNode || i >= len(n.Content) {
+		return nil
+	}
+	return n.Content[i]
+}
+
+// validateConfig checks the configuration for mistakes that decoding can't
+// catch. src tells where things were defined, for the error messages.
+func validateConfig(c Config, src configSource) ConfigErrors {
+	doc := src.doc
+	var errs ConfigErrors
+	addIn := func(file string, line int, format string, args ...any) {
+		errs = append(errs, ConfigError{File: file, Line: line, Msg: fmt.Sprintf(format, args...)})
+	}
+	add := func(line int, format string, args ...any) {
+		addIn("", line, format, args...)
+	}
+
+	if c.Interval < 0 || c.IntervalMinutes < 0 {
+		add(lineOf(doc, "interval"), "interval must be positive")
+	} else if c.intervalSeconds() == 0 {
+		add(lineOf(doc, "interval"), "interval is required")
+	}
+	if c.Retry.Attempts < 0 || c.Retry.Delay < 0 || c.Retry.MaxDelay < 0 {
+		add(lineOf(doc, "retry"), "retry settings must not be negative")
+	}
+	if c.Webhook.Listen != "" && c.Webhook.Secret == "" {
+		add(lineOf(mappingValue(doc, "webhook"), "listen"), "webhook.secret is required when webhook.listen is set")
+	}
+	patterns := map[string][]string{"include": c.Discovery.Include, "exclude": c.Discovery.Exclude}
+	for _, key := range slices.Sorted(maps.Keys(patterns)) {
+		for _, pattern := range patterns[key] {
+			if _, err := path.Match(pattern, ""); err != nil {
+				add(lineOf(mappingValue(doc, "discovery"), key), "invalid discovery.%s pattern %q", key, pattern)
+			}
+		}
+	}
+	for _, pattern := range c.Redact.Env {
+		if _, err := path.Match(pattern, ""); err != nil {
+			add(lineOf(mappingValue(doc, "redact"), "env"), "invalid redact.env pattern %q", pattern)
+		}
+	}
+
+	notifications := mappingValue(doc, "notifications")
+	for i, n := range c.Notifications {
+		errs = append(errs, validateNotification(n, yamlRef{node: sequenceItem(notifications, i)}, "notification")...)
+	}
+
+	names := map[string]string{} // Where each project was defined
+	containers := map[string]string{}
+	ports := map[string]string{}
+	for i, p := range c.Projects {
+		ref := src.project(i)
+		node := ref.node
+		location := src.file
+		if ref.file != "" {
+			location = ref.file
+		}
+		label := fmt.Sprintf("project %d", i+1)
+		if p.Name == "" {
+			addIn(ref.file, lineOf(node, "name"), "%s: name is required", label)
+		} else {
+			label = "project " + p.Name
+			if other, ok := names[p.Name]; ok {
+				addIn(ref.file, lineOf(node, "name"), "%s: duplicate project name, already defined in %s", label, other)
+			} else {
+				names[p.Name] = location
+			}
+		}
+
+		switch {
+		case p.Type == "" && c.discovers():
+			// Settings for a discovered container
+		case p.Type == "":
+			addIn(ref.file, lineOf(node, "type"), "%s: type is required", label)
+		case !slices.Contains(projectTypes, p.Type):
+			addIn(ref.file, lineOf(node, "type"), "%s: unknown type %q (use %s)", label, p.Type, strings.Join(projectTypes, ", "))
+		case p.Type == "image":
+			if p.Image == "" {
+				addIn(ref.file, lineOf(node, "image"), "%s: image is required for type image", label)
+			} else if !imageReference.MatchString(p.Image) {
+				addIn(ref.file, lineOf(node, "image"), "%s: invalid image reference %q", label, p.Image)
+			}
+			container := p.ContainerName
+			if container == "" {
+				container = p.Name
+			}
+			if other, ok := containers[container]; ok && container != "" {
+				addIn(ref.file, lineOf(node, "containerName"), "%s: container name %q is already used by %s", label, container, other)
+			}
+			containers[container] = label
+			for _, mapping := range strings.Fields(p.Port) {
+				keys, err := hostPorts(mapping)
+				if err != nil {
+					addIn(ref.file, lineOf(node, "port"), "%s: %v", label, err)
+					continue
+				}
+				for _, key := range keys {
+					if other, ok := portConflict(ports, key); ok {
+						addIn(ref.file, lineOf(node, "port"), "%s: host port %s is already used by %s", label, mapping, other)
+						break
+					}
+					ports[key] = label
+				}
+			}
+		default:
+			if p.Path == "" {
+				addIn(ref.file, lineOf(node, "path"), "%s: path is required for type %s", label, p.Type)
+			}
+		}
+
+		if p.RestartPolicy != "" && !validRestartPolicy(p.RestartPolicy) {
+			addIn(ref.file, lineOf(node, "restartPolicy"), "%s: invalid restartPolicy %q (use no, always, unless-stopped or on-failure[:max-retries])", label, p.RestartPolicy)
+		}
+		if p.Schedule < 0 {
+			addIn(ref.file, lineOf(node, "schedule"), "%s: schedule must be positive", label)
+		}
+		if p.Healthcheck != "" && !validHealthcheck(p.Healthcheck) {
+			addIn(ref.file, lineOf(node, "healthcheck"), "%s: healthcheck must be an http or https URL", label)
+		}
+		if p.Retry != nil && (p.Retry.Attempts < 0 || p.Retry.Delay < 0 || p.Retry.MaxDelay < 0) {
+			addIn(ref.file, lineOf(node, "retry"), "%s: retry settings must not be negative", label)
+		}
+		projectNotifications := mappingValue(node, "notifications")
+		for j, n := range p.Notifications {
+			errs = append(errs, validateNotification(n, yamlRef{file: ref.file, node: sequenceItem(projectNotifications, j)}, label+": notification")...)
+		}
+	}
+	return errs
+}
+
+// validRestartPolicy reports whether policy is a restart policy of docker run.
+func validRestartPolicy(policy string) bool {
+	return restartPolicyPattern.MatchString(policy)
+}
+
+func validHealthcheck(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// validateSource checks a local configuration that loads the rest from a
+// git repository: it may only hold the source.
+func validateSource(c Config, doc *yaml.Node) ConfigErrors {
+	var errs ConfigErrors
+	if c.Source.Repo == "" {
+		errs = append(errs, ConfigError{Line: lineOf(doc, "source"), Msg: "source: repo is required"})
+	}
+	if path := c.Source.Path; filepath.IsAbs(path) || strings.HasPrefix(filepath.Clean(path), "..") {
+		errs = append(errs, ConfigError{Line: lineOf(mappingValue(doc, "source"), "path"), Msg: fmt.Sprintf("source: path %q must be inside the repository", path)})
+	}
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		if key := doc.Content[i]; key.Value != "source" {
+			errs = append(errs, ConfigError{Line: key.Line, Msg: fmt.Sprintf("%s: settings belong in the config in the repository when source is set", key.Value)})
+		}
+	}
+	return errs
+}
+
+func validateNotification(n NotificationConfig, ref yamlRef, label string) ConfigErrors {
+	var errs ConfigErrors
+	add := func(key, format string, args ...any) {
+		errs = append(errs, ConfigError{File: ref.file, Line: lineOf(ref.node, key), Msg: label + ": " + fmt.Sprintf(format, args...)})
+	}
+
+	if !slices.Contains(notificationTypes, n.Type) {
+		add("type", "unknown type %q (use %s)", n.Type, strings.Join(notificationTypes, ", "))
+		return errs
+	}
+	for _, event := range n.Events {
+		if !slices.Contains(notifyEvents, event) {
+			add("events", "unknown event %q (use %s)", event, strings.Join(notifyEvents, ", "))
+		}
+	}
+	for event := range n.Priority {
+		if !slices.Contains(notifyEvents, event) {
+			add("priority", "unknown event %q", event)
+		}
+	}
+
+	switch n.Type {
+	case "email":
+		if n.SMTP == nil || n.SMTP.Host == "" {
+			add("smtp", "smtp.host is required")
+		} else if !slices.Contains([]string{"", "starttls", "tls", "none"}, n.SMTP.TLS) {
+			add("smtp", "unknown smtp.tls mode %q (use starttls, tls or none)", n.SMTP.TLS)
+		}
+		if len(n.To) == 0 {
+			add("to", "at least one recipient is required")
+		}
+		if n.Digest != "" {
+			if _, err := time.Parse("15:04", n.Digest); err != nil {
+				add("digest", "digest must be a time of day like 08:00")
+			}
+		}
+	case "ntfy":
+		if n.Topic == "" {
+			add("topic", "topic is required")
+		}
+	case "gotify":
+		if n.URL == "" || n.Token == "" {
+			add("url", "url and token are required")
+		}
+	case "mqtt":
+		if !strings.HasPrefix(n.URL, "mqtt://") && !strings.HasPrefix(n.URL, "mqtts://") &&
+			!strings.HasPrefix(n.URL, "tcp://") && !strings.HasPrefix(n.URL, "ssl://") && !strings.HasPrefix(n.URL, "tls://") {
+			add("url", "url must be an mqtt:// or mqtts:// broker address")
+		}
+	default:
+		if n.URL == "" {
+			add("url", "url is required")
+		}
+	}
+	return errs
+}
+
+// hostPorts returns the host ports a docker -p mapping binds, as
+// "ip:port/proto" keys, e.g. 127.0.0.1:8080:80/tcp. Mappings without a host
+// port bind a random one and return nothing.
+func hostPorts(mapping string) ([]string, error) {
+	spec, proto, _ := strings.Cut(mapping, "/")
+	if proto == "" {
+		proto = "tcp"
+	}
+	ip := "0.0.0.0"
+	if strings.HasPrefix(spec, "[") {
+		// IPv6 address, e.g. [::1]:8080:80
+		end := strings.Index(spec, "]:")
+		if end == -1 {
+			return nil, fmt.Errorf("invalid port mapping %q", mapping)
+		}
+		ip, spec = spec[1:end], "-:"+spec[end+2:]
+	}
+	parts := strings.Split(spec, ":")
+	host := ""
+	switch len(parts) {
+	case 1:
+		return nil, nil
+	case 2:
+		host = parts[0]
+	case 3:
+		host = parts[1]
+		if parts[0] != "-" {
+			ip = parts[0]
+		}
+	default:
+		return nil, fmt.Errorf("invalid port mapping %q", mapping)
+	}
+	if host == "" {
+		return nil, nil
+	}
+
+	low, high, isRange := strings.Cut(host, "-")
+	first, err1 := strconv.Atoi(low)
+	last, err2 := first, error(nil)
+	if isRange {
+		last, err2 = strconv.Atoi(high)
+	}
+	if err1 != nil || err2 != nil || first < 1 || last > 65535 || last < first {
+		return nil, fmt.Errorf("invalid port mapping %q", mapping)
+	}
+
+	var keys []string
+	for port := first; port <= last; port++ {
+		key := fmt.Sprintf("%d/%s", port, proto)
+		if ip != "0.0.0.0" && ip != "" {
+			key = ip + ":" + key
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// portConflict returns who already binds the host port of key. A port bound
+// on all interfaces conflicts with any other binding of that port.
+func portConflict(ports map[string]string, key string) (string, bool) {
+	if other, ok := ports[key]; ok {
+		return other, true
+	}
+	if i := strings.LastIndex(key, ":"); i != -1 {
+		other, ok := ports[key[i+1:]]
+		return other, ok
+	}
+	for k, other := range ports {
+		if strings.HasSuffix(k, ":"+key) {
+			return other, true
+		}
+	}
+	return "", false
+}
+
+var validateCmd = &cobra.Command{
+	Use:   "validate [file]",
+	Short: "Check the configuration file for errors",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		file := configPath()
+		if len(args) == 1 {
+			file = args[0]
+		}
+
+		c, err := readConfigFile(file)
+		if err == nil && c.Source != nil {
+			// Check the latest config in the repository
+			file = redact(c.Source.Repo)
+			c, err = readSourceConfig(*c.Source, true)
+		}
+		var configErrs ConfigErrors
+		if err != nil && !errors.As(err, &configErrs) {
+			fmt.Println("Failed to read config:", err)
+			os.Exit(1)
+		} else if err != nil {
+			fmt.Printf("✘ %s is invalid:\n", file)
+			for _, line := range strings.Split(err.Error(), "\n") {
+				fmt.Println("  " + line)
+			}
+			os.Exit(1)
+		}
+		fmt.Printf("✓ %s is valid (%d projects)\n", file, len(c.Projects))
+	},
+}
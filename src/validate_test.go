@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestHostPorts(t *testing.T) {
+	tests := []struct {
+		mapping string
+		want    []string
+		wantErr bool
+	}{
+		{mapping: "80"},
+		{mapping: ":80"},
+		{mapping: "8080:80", want: []string{"8080/tcp"}},
+		{mapping: "8080:80/udp", want: []string{"8080/udp"}},
+		{mapping: "127.0.0.1:8080:80", want: []string{"127.0.0.1:8080/tcp"}},
+		{mapping: "0.0.0.0:8080:80", want: []string{"8080/tcp"}},
+		{mapping: "127.0.0.1::80"},
+		{mapping: "[::1]:8080:80", want: []string{"::1:8080/tcp"}},
+		{mapping: "8000-8002:8000-8002", want: []string{"8000/tcp", "8001/tcp", "8002/tcp"}},
+		{mapping: "http:80", wantErr: true},
+		{mapping: "0:80", wantErr: true},
+		{mapping: "65536:80", wantErr: true},
+		{mapping: "8002-8000:80", wantErr: true},
+		{mapping: "1:2:3:4", wantErr: true},
+		{mapping: "[::1:8080:80", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := hostPorts(tt.mapping)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("hostPorts(%q) error = %v, want error %v", tt.mapping, err, tt.wantErr)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("hostPorts(%q) = %q, want %q", tt.mapping, got, tt.want)
+		}
+	}
+}
+
+func TestPortConflict(t *testing.T) {
+	ports := map[string]string{
+		"8080/tcp":           "web",
+		"127.0.0.1:9000/tcp": "api",
+	}
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"8080/tcp", "web"},
+		{"127.0.0.1:8080/tcp", "web"},
+		{"9000/tcp", "api"},
+		{"127.0.0.1:9000/tcp", "api"},
+		{"10.0.0.1:9000/tcp", ""},
+		{"8080/udp", ""},
+		{"3000/tcp", ""},
+	}
+	for _, tt := range tests {
+		got, ok := portConflict(ports, tt.key)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("portConflict(%q) = %q, %v, want %q", tt.key, got, ok, tt.want)
+		}
+	}
+}
+
+func TestImageReference(t *testing.T) {
+	tests := []struct {
+		image string
+		valid bool
+	}{
+		{"nginx", true},
+		{"nginx:1.27", true},
+		{"library/nginx:latest", true},
+		{"ghcr.io/acme/web:main", true},
+		{"localhost:5000/web", true},
+		{"registry.example.com:5000/team/web_app:v1.2.3-rc.1", true},
+		{"redis@sha256:" + strings.Repeat("a", 64), true},
+		{"Nginx", false},
+		{"nginx:", false},
+		{"nginx:-1", false},
+		{"ghcr.io/acme/web::main", false},
+		{"redis@sha256:abc", false},
+		{"https://ghcr.io/acme/web", false},
+		{"web app", false},
+	}
+	for _, tt := range tests {
+		if got := imageReference.MatchString(tt.image); got != tt.valid {
+			t.Errorf("imageReference.MatchString(%q) = %v, want %v", tt.image, got, tt.valid)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	decode := func(data string, v any) error {
+		dec := yaml.NewDecoder(strings.NewReader(data))
+		dec.KnownFields(true)
+		return dec.Decode(v)
+	}
+	tests := []struct {
+		name string
+		file string
+		data string
+		v    any
+		want []string
+	}{
+		{
+			name: "unknown field",
+			data: "projects:\n  - name: web\n    buildComand: make\n",
+			v:    &Config{},
+			want: []string{`line 3: unknown field "buildComand"`},
+		},
+		{
+			name: "unknown field in an included file",
+			file: "projects.yaml",
+			data: "webhook:\n  port: 9000\n",
+			v:    &includeFile{},
+			want: []string{`projects.yaml:1: unknown field "webhook", included files can only define projects`},
+		},
+		{
+			name: "type errors",
+			data: "projects:\n  - name: web\n    schedule: hourly\n    env: [A]\n",
+			v:    &Config{},
+			want: []string{
+				"line 3: cannot unmarshal !!str `hourly` into int",
+				"line 4: cannot unmarshal !!seq into map[string]string",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errs ConfigErrors
+			if !errors.As(decodeErrors(tt.file, decode(tt.data, tt.v)), &errs) {
+				t.Fatalf("decodeErrors returned no ConfigErrors for %q", tt.data)
+			}
+			got := make([]string, len(errs))
+			for i, e := range errs {
+				got[i] = e.Error()
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("errors = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Syntax errors have no line to report per problem
+	err := decodeErrors("projects.yaml", decode("projects: [", &Config{}))
+	var errs ConfigErrors
+	if err == nil || errors.As(err, &errs) || !strings.HasPrefix(err.Error(), "projects.yaml: ") {
+		t.Errorf("syntax error = %v, want it prefixed with the file", err)
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "updatectrl.yaml")
+	include := filepath.Join(dir, "projects.yaml")
+	main := `interval: 300
+include: [projects.yaml]
+projects:
+  - name: web
+    type: imgae
+    image: nginx
+    buildComand: make
+  - name: api
+    type: image
+    image: ghcr.io/acme/api
+    port: 8080:80
+`
+	included := `projects:
+  - name: worker
+    type: image
+    image: ghcr.io/acme/worker
+    port: 8080:8080
+    restart: always
+`
+	if err := os.WriteFile(include, []byte(included), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := parseConfig(path, []byte(main))
+	var errs ConfigErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("parseConfig = %v, want ConfigErrors", err)
+	}
+	got := make([]string, len(errs))
+	for i, e := range errs {
+		got[i] = e.Error()
+	}
+	want := []string{
+		`line 5: project web: unknown type "imgae" (use ` + strings.Join(projectTypes, ", ") + `)`,
+		`line 7: unknown field "buildComand"`,
+		include + `:5: project worker: host port 8080:8080 is already used by project api`,
+		include + `:6: unknown field "restart"`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("errors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Once fixed, the same file parses with its included projects
+	main = strings.NewReplacer("imgae", "image", "    buildComand: make\n", "", "8080:80", "8081:80").Replace(main)
+	if err := os.WriteFile(include, []byte(strings.Replace(included, "    restart: always\n", "", 1)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := parseConfig(path, []byte(main))
+	if err != nil {
+		t.Fatalf("parseConfig = %v", err)
+	}
+	var names []string
+	for _, p := range c.Projects {
+		names = append(names, p.Name)
+	}
+	if want := []string{"web", "api", "worker"}; !slices.Equal(names, want) {
+		t.Errorf("projects = %q, want %q", names, want)
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const maxWebhookBody = 5 << 20
+
+// webhookEvent is what a push or package webhook tells us changed.
+type webhookEvent struct {
+	repoURLs []string // Git repository URLs for push events
+	branch   string
+	image    string // Image repository for registry events, e.g. "ghcr.io/user/app"
+	tag      string
+}
+
+// serveWebhooks listens for push and registry webhooks and triggers an
+// immediate check of the projects they refer to.
+func (d *daemon) serveWebhooks(cfg WebhookConfig) {
+	if cfg.Secret == "" {
+		slog.Error("Webhook listener disabled: webhook.secret must be set")
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /webhook", func(w http.ResponseWriter, r *http.Request) {
+		d.handleWebhook(w, r, cfg.Secret)
+	})
+
+	// The listener is usually exposed to the internet, so don't let slow
+	// clients hold connections open
+	server := &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       time.Minute,
+	}
+	logStep(slog.Default(), "Webhook listener started", "address", cfg.Listen)
+	if err := server.ListenAndServe(); err != nil {
+		slog.Error("Webhook listener stopped", "error", err)
+	}
+}
+
+func (d *daemon) handleWebhook(w http.ResponseWriter, r *http.Request, secret string) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		code := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			code = http.StatusRequestEntityTooLarge
+		}
+		writeError(w, code, err)
+		return
+	}
+
+	event, err := parseWebhook(r, body, secret)
+	if err != nil {
+		slog.Warn("Rejected webhook", "error", err)
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	if event == nil {
+		// Ping or an event we don't act on
+		writeJSON(w, http.StatusOK, triggerRequest{Projects: []string{}})
+		return
+	}
+
+	var names []string
+	for _, p := range d.projects() {
+		if event.matches(p) {
+			names = append(names, p.Name)
+		}
+	}
+	if len(names) == 0 {
+		writeJSON(w, http.StatusOK, triggerRequest{Projects: []string{}})
+		return
+	}
+
+	logStep(slog.Default(), "Webhook received", "projects", strings.Join(names, ", "))
+	if err := d.trigger(names); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, triggerRequest{Projects: names})
+}
+
+// parseWebhook verifies the request and extracts the event from it. It
+// returns nil for verified events that don't signal a change.
+func parseWebhook(r *http.Request, body []byte, secret string) (*webhookEvent, error) {
+	switch {
+	case r.Header.Get("X-Gitea-Event") != "":
+		if !validHMAC(body, secret, r.Header.Get("X-Gitea-Signature")) {
+			return nil, fmt.Errorf("invalid Gitea signature")
+		}
+		if r.Header.Get("X-Gitea-Event") != "push" {
+			return nil, nil
+		}
+		return parsePushEvent(body)
+
+	case r.Header.Get("X-GitHub-Event") != "":
+		signature, ok := strings.CutPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256=")
+		if !ok || !validHMAC(body, secret, signature) {
+			return nil, fmt.Errorf("invalid GitHub signature")
+		}
+		switch r.Header.Get("X-GitHub-Event") {
+		case "push":
+			return parsePushEvent(body)
+		case "package", "registry_package":
+			return parseGHCREvent(body)
+		}
+		return nil, nil
+
+	case r.Header.Get("X-Gitlab-Event") != "":
+		if !equalSecret(r.Header.Get("X-Gitlab-Token"), secret) {
+			return nil, fmt.Errorf("invalid GitLab token")
+		}
+		if r.Header.Get("X-Gitlab-Event") != "Push Hook" {
+			return nil, nil
+		}
+		return parseGitLabEvent(body)
+	}
+
+	// Docker Hub doesn't sign its webhooks, so the secret is passed in the URL
+	if !equalSecret(r.URL.Query().Get("token"), secret) {
+		return nil, fmt.Errorf("invalid or missing token")
+	}
+	return parseDockerHubEvent(body)
+}
+
+func validHMAC(body []byte, secret, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+func equalSecret(got, secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(got), []byte(secret)) == 1
+}
+
+func parsePushEvent(body []byte) (*webhookEvent, error) {
+	var payload struct {
+		Ref        string `json:"ref"`
+		Repository struct {
+			CloneURL string `json:"clone_url"`
+			SSHURL   string `json:"ssh_url"`
+			HTMLURL  string `json:"html_url"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("could not parse push event: %w", err)
+	}
+	branch, ok := strings.CutPrefix(payload.Ref, "refs/heads/")
+	if !ok {
+		return nil, nil // Tag pushes don't move branches
+	}
+	return &webhookEvent{
+		repoURLs: []string{payload.Repository.CloneURL, payload.Repository.SSHURL, payload.Repository.HTMLURL},
+		branch:   branch,
+	}, nil
+}
+
+func parseGitLabEvent(body []byte) (*webhookEvent, error) {
+	var payload struct {
+		Ref     string `json:"ref"`
+		Project struct {
+			HTTPURL string `json:"git_http_url"`
+			SSHURL  string `json:"git_ssh_url"`
+			WebURL  string `json:"web_url"`
+		} `json:"project"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("could not parse push event: %w", err)
+	}
+	branch, ok := strings.CutPrefix(payload.Ref, "refs/heads/")
+	if !ok {
+		return nil, nil
+	}
+	return &webhookEvent{
+		repoURLs: []string{payload.Project.HTTPURL, payload.Project.SSHURL, payload.Project.WebURL},
+		branch:   branch,
+	}, nil
+}
+
+func parseGHCREvent(body []byte) (*webhookEvent, error) {
+	var payload struct {
+		Package struct {
+			Name        string `json:"name"`
+			PackageType string `json:"package_type"`
+			Owner       struct {
+				Login string `json:"login"`
+			} `json:"owner"`
+			PackageVersion struct {
+				ContainerMetadata struct {
+					Tag struct {
+						Name string `json:"name"`
+					} `json:"tag"`
+				} `json:"container_metadata"`
+			} `json:"package_version"`
+		} `json:"package"`
+		RegistryPackage *json.RawMessage `json:"registry_package"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("could not parse package event: %w", err)
+	}
+	if payload.RegistryPackage != nil {
+		// registry_package events carry the same fields under another key
+		if err := json.Unmarshal(*payload.RegistryPackage, &payload.Package); err != nil {
+			return nil, fmt.Errorf("could not parse package event: %w", err)
+		}
+	}
+
+	pkg := payload.Package
+	if !strings.EqualFold(pkg.PackageType, "container") || pkg.Name == "" {
+		return nil, nil
+	}
+	return &webhookEvent{
+		image: "ghcr.io/" + strings.ToLower(pkg.Owner.Login) + "/" + strings.ToLower(pkg.Name),
+		tag:   pkg.PackageVersion.ContainerMetadata.Tag.Name,
+	}, nil
+}
+
+func parseDockerHubEvent(body []byte) (*webhookEvent, error) {
+	var payload struct {
+		PushData struct {
+			Tag string `json:"tag"`
+		} `json:"push_data"`
+		Repository struct {
+			RepoName string `json:"repo_name"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("could not parse Docker Hub event: %w", err)
+	}
+	if payload.Repository.RepoName == "" {
+		return nil, fmt.Errorf("unrecognized webhook payload")
+	}
+	return &webhookEvent{
+		image: normalizeImageRepository(payload.Repository.RepoName),
+		tag:   payload.PushData.Tag,
+	}, nil
+}
+
+func (e *webhookEvent) matches(p Project) bool {
+	if e.image != "" {
+		if p.Type != "image" || p.Image == "" {
+			return false
+		}
+		return normalizeImageRepository(imageRepository(p.Image)) == e.image &&
+			(e.tag == "" || imageTag(p.Image) == e.tag)
+	}
+
+	if p.Type == "image" {
+		return false
+	}
+	repo := p.Repo
+	if repo == "" {
+		output, err := exec.Command("git", "-C", p.Path, "remote", "get-url", "origin").Output()
+		if err != nil {
+			return false
+		}
+		repo = strings.TrimSpace(string(output))
+	}
+
+	matched := false
+	for _, url := range e.repoURLs {
+		if url != "" && normalizeRepoURL(url) == normalizeRepoURL(repo) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return false
+	}
+
+	output, err := exec.Command("git", "-C", p.Path, "rev-parse", "--abbrev-ref", "--symbolic-full-name", "@{u}").Output()
+	if err != nil {
+		return true // Can't tell which branch is deployed, so check anyway
+	}
+	_, branch, _ := strings.Cut(strings.TrimSpace(string(output)), "/")
+	return branch == e.branch
+}
+
+// normalizeRepoURL reduces the different URL forms of a repository, such as
+// "git@github.com:user/app.git" and "https://github.com/user/app", to
+// "github.com/user/app".
+func normalizeRepoURL(url string) string {
+	url = strings.ToLower(strings.TrimSpace(url))
+	if i := strings.Index(url, "://"); i != -1 {
+		url = url[i+3:]
+	} else if at := strings.Index(url, "@"); at != -1 {
+		// scp-like syntax: git@host:path
+		url = strings.Replace(url[at+1:], ":", "/", 1)
+	}
+	if at := strings.Index(url, "@"); at != -1 && at < strings.Index(url, "/") {
+		url = url[at+1:]
+	}
+	// Drop the port, as in ssh://git@github.com:22/user/app.git
+	if host, path, ok := strings.Cut(url, "/"); ok {
+		if i := strings.LastIndex(host, ":"); i != -1 {
+			url = host[:i] + "/" + path
+		}
+	}
+	url = strings.TrimSuffix(url, "/")
+	return strings.TrimSuffix(url, ".git")
+}
+
+// normalizeImageRepository expands Docker Hub short names, so "nginx" and
+// "docker.io/library/nginx" compare equal.
+func normalizeImageRepository(repo string) string {
+	repo = strings.ToLower(repo)
+	first, _, hasSlash := strings.Cut(repo, "/")
+	if !hasSlash {
+		return "docker.io/library/" + repo
+	}
+	if !strings.ContainsAny(first, ".:") && first != "localhost" {
+		return "docker.io/" + repo
+	}
+	return repo
+}
+
+// imageTag returns the tag of an image reference, "latest" if it has none.
+func imageTag(image string) string {
+	if i := strings.Index(image, "@"); i != -1 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[i+1:]
+	}
+	return "latest"
+}